@@ -0,0 +1,309 @@
+package base
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule 触发时间规则
+type Schedule interface {
+	// Next 返回after之后的下一次触发时间，没有时返回零值
+	Next(after time.Time) time.Time
+}
+
+// CronSchedule cron表达式，精确到分钟，按指定时区计算
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// 日期和星期都有限制时满足其一即可，和标准cron一致
+	domStar, dowStar bool
+	loc              *time.Location
+}
+
+// Location 计算使用的时区
+func (c *CronSchedule) Location() *time.Location {
+	return c.loc
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	"sunday": 0, "monday": 1, "tuesday": 2, "wednesday": 3, "thursday": 4, "friday": 5, "saturday": 6,
+}
+
+// ParseCron 解析5段cron表达式：分 时 日 月 星期
+// 支持 * , - / 、月份和星期的英文缩写、@daily等描述符，
+// 可以用"CRON_TZ=Asia/Shanghai "前缀指定时区，默认本地时区
+func ParseCron(expr string) (*CronSchedule, error) {
+	loc := time.Local
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		i := strings.IndexByte(expr, ' ')
+		if i < 0 {
+			return nil, fmt.Errorf("cron: missing fields in %q", expr)
+		}
+		var err error
+		if loc, err = time.LoadLocation(expr[strings.IndexByte(expr, '=')+1 : i]); err != nil {
+			return nil, err
+		}
+		expr = strings.TrimSpace(expr[i:])
+	}
+	if d, ok := cronDescriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %d in %q", len(fields), expr)
+	}
+	c := &CronSchedule{loc: loc}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, err
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return nil, err
+	}
+	// 7也表示星期天
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	c.domStar = fields[2] == "*" || fields[2] == "?"
+	c.dowStar = fields[4] == "*" || fields[4] == "?"
+	return c, nil
+}
+
+// parseCronField 解析一段，返回位集合
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("cron: bad step in %q", field)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case part == "*" || part == "?":
+		case strings.IndexByte(part, '-') > 0:
+			i := strings.IndexByte(part, '-')
+			var err error
+			if lo, err = cronValue(part[:i], names); err != nil {
+				return 0, err
+			}
+			if hi, err = cronValue(part[i+1:], names); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := cronValue(part, names)
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = v, v
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("cron: %q out of range [%d, %d]", field, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func cronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("cron: bad value %q", s)
+	}
+	return v, nil
+}
+
+// dayMatches 日期是否满足
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next 下一次触发时间，5年内找不到时返回零值
+func (c *CronSchedule) Next(after time.Time) time.Time {
+	loc := c.loc
+	t := after.In(loc).Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + 5
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+	for c.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+	for !c.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+	for c.hour&(1<<uint(t.Hour())) == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+	for c.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+	return t
+}
+
+// Daily 每天的hour:minute，loc为nil时使用本地时区
+func Daily(hour, minute int, loc *time.Location) *CronSchedule {
+	if loc == nil {
+		loc = time.Local
+	}
+	return &CronSchedule{
+		minute:  1 << uint(minute),
+		hour:    1 << uint(hour),
+		dom:     cronAll(1, 31),
+		month:   cronAll(1, 12),
+		dow:     cronAll(0, 6),
+		domStar: true,
+		dowStar: true,
+		loc:     loc,
+	}
+}
+
+// Weekly 每周day的hour:minute，loc为nil时使用本地时区
+func Weekly(day time.Weekday, hour, minute int, loc *time.Location) *CronSchedule {
+	c := Daily(hour, minute, loc)
+	c.dow = 1 << uint(day)
+	c.dowStar = false
+	return c
+}
+
+func cronAll(min, max int) uint64 {
+	var bits uint64
+	for v := min; v <= max; v++ {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+// ParseSchedule 解析游戏日历规则或者cron表达式，例如：
+//
+//	daily at 05:00 Asia/Shanghai
+//	every day 05:00
+//	every Monday 00:00 UTC
+//	weekly on fri at 20:30
+//	CRON_TZ=Asia/Shanghai 0 5 * * *
+//
+// 不写时区时使用本地时区
+func ParseSchedule(spec string) (Schedule, error) {
+	words := strings.Fields(strings.ToLower(spec))
+	if len(words) == 0 || (words[0] != "daily" && words[0] != "weekly" && words[0] != "every") {
+		return ParseCron(spec)
+	}
+	// 保留时区原始大小写
+	orig := strings.Fields(spec)
+
+	i := 1
+	daily := words[0] == "daily"
+	weekday := -1
+	if words[0] == "every" && i < len(words) {
+		if words[i] == "day" {
+			daily = true
+			i++
+		}
+	}
+	if !daily {
+		if i < len(words) && words[i] == "on" {
+			i++
+		}
+		if i >= len(words) {
+			return nil, fmt.Errorf("schedule: missing weekday in %q", spec)
+		}
+		v, ok := weekdayNames[strings.TrimSuffix(words[i], "s")]
+		if !ok {
+			return nil, fmt.Errorf("schedule: bad weekday %q", orig[i])
+		}
+		weekday = v
+		i++
+	}
+	if i < len(words) && words[i] == "at" {
+		i++
+	}
+	if i >= len(words) {
+		return nil, fmt.Errorf("schedule: missing time in %q", spec)
+	}
+	clock, err := time.Parse("15:04", words[i])
+	if err != nil {
+		return nil, fmt.Errorf("schedule: bad time %q", orig[i])
+	}
+	i++
+	loc := time.Local
+	if i < len(words) {
+		if loc, err = time.LoadLocation(orig[i]); err != nil {
+			return nil, err
+		}
+		i++
+	}
+	if i != len(words) {
+		return nil, fmt.Errorf("schedule: unexpected %q in %q", orig[i], spec)
+	}
+	if daily {
+		return Daily(clock.Hour(), clock.Minute(), loc), nil
+	}
+	return Weekly(time.Weekday(weekday), clock.Hour(), clock.Minute(), loc), nil
+}
+
+// UntilNext 距离下一次触发的时间，可以用于界面倒计时
+func UntilNext(s Schedule) time.Duration {
+	now := time.Now()
+	next := s.Next(now)
+	if next.IsZero() {
+		return 0
+	}
+	return next.Sub(now)
+}
@@ -0,0 +1,40 @@
+package base
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	from := time.Date(2024, 3, 1, 4, 30, 0, 0, shanghai) // 星期五
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"daily at 05:00 Asia/Shanghai", time.Date(2024, 3, 1, 5, 0, 0, 0, shanghai)},
+		{"every Monday 00:00 Asia/Shanghai", time.Date(2024, 3, 4, 0, 0, 0, 0, shanghai)},
+		{"weekly on fri at 04:30 Asia/Shanghai", time.Date(2024, 3, 8, 4, 30, 0, 0, shanghai)},
+		{"CRON_TZ=Asia/Shanghai */15 9-10 * * mon-fri", time.Date(2024, 3, 1, 9, 0, 0, 0, shanghai)},
+		{"CRON_TZ=Asia/Shanghai 0 0 31 * *", time.Date(2024, 3, 31, 0, 0, 0, 0, shanghai)},
+		{"CRON_TZ=Asia/Shanghai 0 12 15 * 7", time.Date(2024, 3, 3, 12, 0, 0, 0, shanghai)},
+		{"CRON_TZ=UTC @daily", time.Date(2024, 3, 1, 8, 0, 0, 0, shanghai)},
+	}
+	for _, c := range cases {
+		s, err := ParseSchedule(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", c.spec, err)
+		}
+		if got := s.Next(from); !got.Equal(c.want) {
+			t.Fatalf("%s: got %v, want %v", c.spec, got, c.want)
+		}
+	}
+	for _, bad := range []string{"every someday 05:00", "daily at 25:00", "* * *", "61 * * * *"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Fatalf("%q should not parse", bad)
+		}
+	}
+}
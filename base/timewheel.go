@@ -0,0 +1,246 @@
+package base
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+TimingWheel 分层时间轮
+
+用于大量的超时检查（认证超时、空闲超时、RPC超时等），添加和取消都是O(1)，
+不依赖运行时定时器，由使用者在自己的循环里调用Advance/Poll推进：
+
+  - 第0层每个槽是一个tick，第l层每个槽是slots^l个tick，层数决定最大时长
+  - 超过最大时长的定时器放在最高层，到期前会重新计算位置
+  - 回调在调用Advance的goroutine中执行，精度为一个tick，不会提前触发
+  - 从空变为非空时通知Wake，推进的循环可以只在有定时器时计时
+*/
+type TimingWheel struct {
+	mu      sync.Mutex
+	tick    time.Duration
+	bits    uint
+	mask    int64
+	levels  [][]wheelBucket
+	start   time.Time
+	current int64 // 已经推进的tick数
+	count   int
+	wake    chan struct{}
+}
+
+// wheelBucket 槽，定时器双向链表
+type wheelBucket struct {
+	head *WheelTimer
+}
+
+// WheelTimer 时间轮定时器
+type WheelTimer struct {
+	expire     int64
+	fn         func()
+	wheel      *TimingWheel
+	bucket     *wheelBucket
+	prev, next *WheelTimer
+}
+
+const (
+	defaultWheelBits   = 8
+	defaultWheelLevels = 4
+)
+
+// NewTimingWheel 创建时间轮，tick为精度，默认每层256个槽，4层
+func NewTimingWheel(tick time.Duration) *TimingWheel {
+	return NewTimingWheelWithLevels(tick, defaultWheelBits, defaultWheelLevels)
+}
+
+// NewTimingWheelWithLevels 创建时间轮，每层1<<bits个槽，共levels层
+func NewTimingWheelWithLevels(tick time.Duration, bits uint, levels int) *TimingWheel {
+	if tick <= 0 {
+		tick = time.Millisecond
+	}
+	if bits == 0 || bits > 16 {
+		bits = defaultWheelBits
+	}
+	if levels <= 0 || int(bits)*levels > 62 {
+		levels = defaultWheelLevels
+	}
+	w := &TimingWheel{
+		tick:   tick,
+		bits:   bits,
+		mask:   1<<bits - 1,
+		levels: make([][]wheelBucket, levels),
+		start:  time.Now(),
+		wake:   make(chan struct{}, 1),
+	}
+	for i := range w.levels {
+		w.levels[i] = make([]wheelBucket, 1<<bits)
+	}
+	return w
+}
+
+// Tick 精度
+func (w *TimingWheel) Tick() time.Duration {
+	return w.tick
+}
+
+// Len 等待中的定时器数量
+func (w *TimingWheel) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// Wake 时间轮从空变为非空时收到通知
+func (w *TimingWheel) Wake() <-chan struct{} {
+	return w.wake
+}
+
+// AfterFunc d时间之后在推进时间轮的goroutine中执行f
+// 到期时间从当前时间计算，时间轮很久没有推进时也不会提前触发
+func (w *TimingWheel) AfterFunc(d time.Duration, f func()) *WheelTimer {
+	if d < 0 {
+		d = 0
+	}
+	expire := int64((time.Since(w.start) + d + w.tick - 1) / w.tick)
+	w.mu.Lock()
+	if expire <= w.current {
+		expire = w.current + 1
+	}
+	t := &WheelTimer{
+		expire: expire,
+		fn:     f,
+		wheel:  w,
+	}
+	w.add(t)
+	w.count++
+	if w.count == 1 {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+	w.mu.Unlock()
+	return t
+}
+
+// Stop 取消定时器，返回false表示已经触发或者已经取消
+func (t *WheelTimer) Stop() bool {
+	w := t.wheel
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.bucket == nil {
+		return false
+	}
+	t.unlink()
+	w.count--
+	return true
+}
+
+// add 按到期时间放入对应层的槽，需要加锁
+func (w *TimingWheel) add(t *WheelTimer) {
+	delta := t.expire - w.current
+	if delta < 0 {
+		delta = 0
+	}
+	top := len(w.levels) - 1
+	for l := 0; l <= top; l++ {
+		if delta < int64(1)<<(w.bits*uint(l+1)) {
+			w.link(t, &w.levels[l][(t.expire>>(w.bits*uint(l)))&w.mask])
+			return
+		}
+	}
+	// 超过最大时长，先放在最高层最远的槽，级联时重新计算
+	far := w.current + int64(1)<<(w.bits*uint(top+1)) - 1
+	w.link(t, &w.levels[top][(far>>(w.bits*uint(top)))&w.mask])
+}
+
+func (w *TimingWheel) link(t *WheelTimer, b *wheelBucket) {
+	t.bucket = b
+	t.prev = nil
+	t.next = b.head
+	if b.head != nil {
+		b.head.prev = t
+	}
+	b.head = t
+}
+
+func (t *WheelTimer) unlink() {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		t.bucket.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.bucket, t.prev, t.next = nil, nil, nil
+}
+
+// step 推进一个tick，把到期的回调追加到expired，需要加锁
+func (w *TimingWheel) step(expired []func()) []func() {
+	w.current++
+	// 低层转完一圈时，把高层对应槽的定时器级联到低层
+	for l := 1; l < len(w.levels); l++ {
+		if w.current&(int64(1)<<(w.bits*uint(l))-1) != 0 {
+			break
+		}
+		b := &w.levels[l][(w.current>>(w.bits*uint(l)))&w.mask]
+		t := b.head
+		b.head = nil
+		for t != nil {
+			next := t.next
+			w.add(t)
+			t = next
+		}
+	}
+	b := &w.levels[0][w.current&w.mask]
+	for t := b.head; t != nil; {
+		next := t.next
+		t.bucket, t.prev, t.next = nil, nil, nil
+		expired = append(expired, t.fn)
+		w.count--
+		t = next
+	}
+	b.head = nil
+	return expired
+}
+
+// Poll 推进到now，返回到期的回调，由调用者决定如何执行
+// 给EpollReactor、Processor等自己有循环的使用者集成
+func (w *TimingWheel) Poll(now time.Time) []func() {
+	target := int64(now.Sub(w.start) / w.tick)
+	var expired []func()
+	w.mu.Lock()
+	if w.count == 0 && target > w.current {
+		// 没有定时器时直接跳过
+		w.current = target
+	}
+	for w.current < target {
+		expired = w.step(expired)
+	}
+	w.mu.Unlock()
+	return expired
+}
+
+// Advance 推进到now，并在当前goroutine中执行到期的回调
+func (w *TimingWheel) Advance(now time.Time) int {
+	expired := w.Poll(now)
+	for _, f := range expired {
+		f()
+	}
+	return len(expired)
+}
+
+// Run 使用自己的ticker推进时间轮，直到stop被关闭
+// 没有其他循环可以集成时使用
+func (w *TimingWheel) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			w.Advance(now)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package base
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimingWheel(t *testing.T) {
+	w := NewTimingWheelWithLevels(time.Millisecond, 2, 2) // 每层4个槽，最大16个tick
+	start := w.start
+	fired := make(map[int]int)
+	for _, d := range []int{1, 3, 4, 9, 15, 40} {
+		d := d
+		w.AfterFunc(time.Duration(d)*time.Millisecond, func() { fired[d] = -1 })
+	}
+	cancelled := w.AfterFunc(5*time.Millisecond, func() { t.Fatalf("cancelled timer fired") })
+	if !cancelled.Stop() || cancelled.Stop() {
+		t.Fatalf("unexpected stop result")
+	}
+
+	// 到期时间从添加时算起，不会提前，最多晚一个tick
+	for tick := 1; tick <= 42; tick++ {
+		w.Advance(start.Add(time.Duration(tick) * time.Millisecond))
+		for d, at := range fired {
+			if at < 0 {
+				fired[d] = tick
+				if tick < d || tick > d+1 {
+					t.Fatalf("timer %d fired at tick %d", d, tick)
+				}
+			}
+		}
+	}
+	if len(fired) != 6 || w.Len() != 0 {
+		t.Fatalf("unexpected fired %v, pending %d", fired, w.Len())
+	}
+}
+
+func TestTimingWheelStale(t *testing.T) {
+	w := NewTimingWheel(time.Millisecond)
+	select {
+	case <-w.Wake():
+		t.Fatal("empty wheel woke")
+	default:
+	}
+	w.AfterFunc(time.Hour, func() {})
+	select {
+	case <-w.Wake():
+	default:
+		t.Fatal("no wake when the first timer was added")
+	}
+
+	// 很久没有推进时添加的定时器也按当前时间计算
+	time.Sleep(20 * time.Millisecond)
+	fired := false
+	w.AfterFunc(10*time.Millisecond, func() { fired = true })
+	w.Advance(time.Now())
+	if fired {
+		t.Fatal("timer fired early on a stale wheel")
+	}
+	w.Advance(time.Now().Add(11 * time.Millisecond))
+	if !fired {
+		t.Fatal("timer did not fire")
+	}
+}
+
+const benchTimers = 1000000
+
+func BenchmarkTimingWheelAfterFunc(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		w := NewTimingWheel(time.Millisecond)
+		timers := make([]*WheelTimer, benchTimers)
+		for j := range timers {
+			timers[j] = w.AfterFunc(time.Duration(j%60000)*time.Millisecond+time.Second, func() {})
+		}
+		for _, t := range timers {
+			t.Stop()
+		}
+	}
+}
+
+func BenchmarkTimeAfterFunc(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		timers := make([]*time.Timer, benchTimers)
+		for j := range timers {
+			timers[j] = time.AfterFunc(time.Duration(j%60000)*time.Millisecond+time.Second, func() {})
+		}
+		for _, t := range timers {
+			t.Stop()
+		}
+	}
+}
+
+func BenchmarkTimingWheelFire(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		w := NewTimingWheel(time.Millisecond)
+		var n int32
+		for j := 0; j < benchTimers; j++ {
+			w.AfterFunc(time.Duration(j%1000)*time.Millisecond, func() { atomic.AddInt32(&n, 1) })
+		}
+		w.Advance(w.start.Add(time.Second + time.Millisecond))
+		if n != benchTimers {
+			b.Fatalf("fired %d", n)
+		}
+	}
+}
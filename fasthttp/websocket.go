@@ -84,18 +84,15 @@ func SetupWebsocket(proc *network.Processor, path string, r *router.Router) {
 				// 为WebSocket创建专用的peer
 				peer := network.NewWebSocketClientPeer(wsConn, proc)
 
-				event := &network.Event{
-					ID:   network.AddEvent,
-					Peer: peer,
-				}
-				proc.EventChan <- event
+				proc.PostEvent(peer.ConnectedEvent())
 				defer func() {
-					leaveEvent := &network.Event{
-						ID:   network.RemoveEvent,
-						Peer: peer,
+					// 可恢复会话挂起时为SuspendEvent
+					if leaveEvent := peer.LeaveEvent(); leaveEvent != nil {
+						peer.Processor().PostEvent(leaveEvent)
 					}
-					proc.EventChan <- leaveEvent
 				}()
+				// 关闭peer，执行会话清理函数
+				defer peer.Close()
 
 				// 使用零拷贝消息读取器处理WebSocket消息
 				reader := network.NewAsyncMessageReader()
@@ -105,6 +102,7 @@ func SetupWebsocket(proc *network.Processor, path string, r *router.Router) {
 					mt, content, err := ws.ReadMessage()
 					if err != nil {
 						//base.Zap().Sugar().Errorf("read websocket message error %v", err)
+						setReadError(peer, err)
 						return
 					}
 					
@@ -117,7 +115,7 @@ func SetupWebsocket(proc *network.Processor, path string, r *router.Router) {
 						// 直接将WebSocket消息投递给零拷贝读取器
 						messages, err := reader.FeedData(content)
 						if err != nil {
-							base.Zap().Sugar().Warnf("message parse error: %v", err)
+							peer.ReportParseError(err)
 							continue
 						}
 						
@@ -129,15 +127,7 @@ func SetupWebsocket(proc *network.Processor, path string, r *router.Router) {
 								Body: zcMsg.GetBody(), // 零拷贝获取消息体
 							}
 							
-							if proc.ImmediateMode {
-								if cb, ok := proc.CallbackMap[msg.Head.ID]; ok {
-									cb(msg)
-								} else if proc.UnHandledHandler != nil {
-									proc.UnHandledHandler(msg)
-								}
-							} else {
-								proc.MessageChan <- msg
-							}
+							peer.Processor().PostMessage(msg)
 							
 							// 释放零拷贝消息
 							zcMsg.Release()
@@ -158,3 +148,13 @@ func SetupWebsocket(proc *network.Processor, path string, r *router.Router) {
 		}
 	}
 }
+
+// setReadError 按读错误记录断开原因，收到关闭帧是对方关闭
+func setReadError(peer *network.ClientPeer, err error) {
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		peer.SetDisconnect(network.DisconnectRemote, nil)
+		return
+	}
+	peer.SetReadError(err)
+}
@@ -81,18 +81,15 @@ func SetupWebsocket(router *gin.Engine, proc *network.Processor) {
 		// 为WebSocket创建专用的peer
 		peer := network.NewWebSocketClientPeer(wsConnection, proc)
 
-		event := &network.Event{
-			ID:   network.AddEvent,
-			Peer: peer,
-		}
 		defer func() {
-			leaveEvent := &network.Event{
-				ID:   network.RemoveEvent,
-				Peer: peer,
+			// 可恢复会话挂起时为SuspendEvent
+			if leaveEvent := peer.LeaveEvent(); leaveEvent != nil {
+				peer.Processor().PostEvent(leaveEvent)
 			}
-			proc.EventChan <- leaveEvent
 		}()
-		proc.EventChan <- event
+		// 关闭peer，执行会话清理函数
+		defer peer.Close()
+		proc.PostEvent(peer.ConnectedEvent())
 
 		// 使用零拷贝消息读取器
 		reader := network.NewAsyncMessageReader()
@@ -103,6 +100,7 @@ func SetupWebsocket(router *gin.Engine, proc *network.Processor) {
 			messageType, messageData, err := ws.ReadMessage()
 			if err != nil {
 				base.Zap().Sugar().Errorf("websocket read error: %v", err)
+				setReadError(peer, err)
 				return
 			}
 
@@ -110,7 +108,7 @@ func SetupWebsocket(router *gin.Engine, proc *network.Processor) {
 				// 直接投递完整消息给FeedData
 				messages, err := reader.FeedData(messageData)
 				if err != nil {
-					base.Zap().Sugar().Warnf("message parse error: %v", err)
+					peer.ReportParseError(err)
 					continue
 				}
 
@@ -122,15 +120,7 @@ func SetupWebsocket(router *gin.Engine, proc *network.Processor) {
 						Body: zcMsg.GetBody(), // 零拷贝获取消息体
 					}
 
-					if proc.ImmediateMode {
-						if cb, ok := proc.CallbackMap[msg.Head.ID]; ok {
-							cb(msg)
-						} else if proc.UnHandledHandler != nil {
-							proc.UnHandledHandler(msg)
-						}
-					} else {
-						proc.MessageChan <- msg
-					}
+					peer.Processor().PostMessage(msg)
 
 					// 释放零拷贝消息
 					zcMsg.Release()
@@ -139,3 +129,13 @@ func SetupWebsocket(router *gin.Engine, proc *network.Processor) {
 		}
 	})
 }
+
+// setReadError 按读错误记录断开原因，收到关闭帧是对方关闭
+func setReadError(peer *network.ClientPeer, err error) {
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		peer.SetDisconnect(network.DisconnectRemote, nil)
+		return
+	}
+	peer.SetReadError(err)
+}
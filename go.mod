@@ -1,6 +1,6 @@
 module github.com/liangpengcheng/qcontinuum
 
-go 1.12
+go 1.18
 
 replace (
 	golang.org/x/crypto => github.com/golang/crypto v0.0.0-20190820162420-60c769a6c586
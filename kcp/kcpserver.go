@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -20,6 +21,10 @@ type AsyncKCPServer struct {
 	running     int32
 	acceptCount uint64
 	connCount   uint64
+	// 服务退出，accept出错或者Stop时关闭，exitErr是出错的原因
+	done     chan struct{}
+	exitOnce sync.Once
+	exitErr  error
 }
 
 // NewAsyncKCPServer 创建异步KCP服务器
@@ -53,6 +58,7 @@ func NewAsyncKCPServer(host string) (*AsyncKCPServer, error) {
 	return &AsyncKCPServer{
 		listener:    lis,
 		reactorPool: reactorPool,
+		done:        make(chan struct{}),
 	}, nil
 }
 
@@ -76,6 +82,9 @@ func (s *AsyncKCPServer) acceptLoop() {
 	for atomic.LoadInt32(&s.running) == 1 {
 		if err := s.acceptOne(); err != nil {
 			base.Zap().Sugar().Errorf("kcp accept error: %v", err)
+			if atomic.LoadInt32(&s.running) == 1 {
+				s.exit(err)
+			}
 			break
 		}
 	}
@@ -110,16 +119,7 @@ func (s *AsyncKCPServer) acceptOne() error {
 	go s.handleKCPConnection(peer, conn)
 
 	// 发送连接事件
-	event := &network.Event{
-		ID:   network.AddEvent,
-		Peer: &network.ClientPeer{AsyncClientPeer: peer},
-	}
-
-	select {
-	case s.processor.EventChan <- event:
-	default:
-		base.Zap().Sugar().Warnf("event queue full, dropping add event")
-	}
+	s.processor.PostEvent(peer.ConnectedEvent())
 
 	atomic.AddUint64(&s.acceptCount, 1)
 	atomic.AddUint64(&s.connCount, 1)
@@ -133,6 +133,11 @@ func (s *AsyncKCPServer) handleKCPConnection(peer *network.AsyncClientPeer, conn
 		peer.Close()
 		conn.Close()
 		atomic.AddUint64(&s.connCount, ^uint64(0)) // 原子递减
+
+		// 发送移除事件，可恢复会话挂起时为SuspendEvent
+		if event := peer.LeaveEvent(); event != nil {
+			peer.Processor().PostEvent(event)
+		}
 	}()
 
 	// KCP连接的读取循环，使用零拷贝缓冲区池
@@ -156,6 +161,7 @@ func (s *AsyncKCPServer) handleKCPConnection(peer *network.AsyncClientPeer, conn
 		if err != nil {
 			buffer.Release()
 			base.Zap().Sugar().Debugf("kcp read error: %v", err)
+			peer.SetReadError(err)
 			break
 		}
 
@@ -169,7 +175,8 @@ func (s *AsyncKCPServer) handleKCPConnection(peer *network.AsyncClientPeer, conn
 		buffer.Release() // 立即释放缓冲区
 
 		if err != nil {
-			base.Zap().Sugar().Warnf("kcp message parse error: %v", err)
+			peer.ReportParseError(err)
+			peer.SetDisconnect(network.DisconnectParseError, err)
 			break
 		}
 
@@ -181,19 +188,7 @@ func (s *AsyncKCPServer) handleKCPConnection(peer *network.AsyncClientPeer, conn
 				Body: zcMsg.GetBody(),
 			}
 
-			if s.processor.ImmediateMode {
-				if cb, ok := s.processor.CallbackMap[msg.Head.ID]; ok {
-					cb(msg)
-				} else if s.processor.UnHandledHandler != nil {
-					s.processor.UnHandledHandler(msg)
-				}
-			} else {
-				select {
-				case s.processor.MessageChan <- msg:
-				default:
-					base.Zap().Sugar().Warnf("message queue full, dropping message")
-				}
-			}
+			peer.Processor().PostMessage(msg)
 
 			zcMsg.Release()
 		}
@@ -203,6 +198,7 @@ func (s *AsyncKCPServer) handleKCPConnection(peer *network.AsyncClientPeer, conn
 // Stop 停止KCP服务器
 func (s *AsyncKCPServer) Stop() {
 	atomic.StoreInt32(&s.running, 0)
+	s.exit(nil)
 
 	if s.listener != nil {
 		s.listener.Close()
@@ -213,6 +209,29 @@ func (s *AsyncKCPServer) Stop() {
 	}
 }
 
+// exit 记录退出原因并关闭Done，只有第一次有效
+func (s *AsyncKCPServer) exit(err error) {
+	s.exitOnce.Do(func() {
+		s.exitErr = err
+		close(s.done)
+	})
+}
+
+// Done 服务退出时关闭，accept出错退出或者调用了Stop
+func (s *AsyncKCPServer) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err Done关闭之后返回退出的原因，Stop退出时为nil
+func (s *AsyncKCPServer) Err() error {
+	select {
+	case <-s.done:
+		return s.exitErr
+	default:
+		return nil
+	}
+}
+
 // GetStats 获取服务器统计信息
 func (s *AsyncKCPServer) GetStats() (acceptCount, connCount uint64) {
 	return atomic.LoadUint64(&s.acceptCount), atomic.LoadUint64(&s.connCount)
@@ -19,6 +19,7 @@ type EpollReactor struct {
 	handlers map[int]AsyncIOHandler
 	mu       sync.RWMutex
 	running  int32
+	wheel    wheelHolder
 }
 
 // NewEpollReactor 创建epoll反应器
@@ -72,7 +73,7 @@ func (r *EpollReactor) Run() {
 	defer atomic.StoreInt32(&r.running, 0)
 
 	for atomic.LoadInt32(&r.running) == 1 {
-		n, err := syscall.EpollWait(r.epfd, r.events, 100)
+		n, err := syscall.EpollWait(r.epfd, r.events, r.wheel.waitMillis(100))
 		if err != nil {
 			if err == syscall.EINTR {
 				continue
@@ -123,6 +124,7 @@ func (r *EpollReactor) Run() {
 				handler.OnError(fd, syscall.ECONNRESET)
 			}
 		}
+		r.wheel.advance()
 	}
 }
 
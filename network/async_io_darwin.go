@@ -22,6 +22,7 @@ type KqueueReactor struct {
 	handlers map[int]AsyncIOHandler
 	mu       sync.RWMutex
 	running  int32
+	wheel    wheelHolder
 }
 
 // NewEpollReactor 创建kqueue反应器（在macOS上使用kqueue）
@@ -119,9 +120,9 @@ func (r *EpollReactor) Run() {
 	atomic.StoreInt32(&r.running, 1)
 	defer atomic.StoreInt32(&r.running, 0)
 
-	timeout := &syscall.Timespec{Sec: 0, Nsec: 100000000} // 100ms
-
 	for atomic.LoadInt32(&r.running) == 1 {
+		// 默认100ms，设置了时间轮时不超过时间轮精度
+		timeout := &syscall.Timespec{Sec: 0, Nsec: int64(r.wheel.waitMillis(100)) * 1000000}
 		n, err := syscall.Kevent(r.kq, nil, r.events, timeout)
 		if err != nil {
 			if err == syscall.EINTR {
@@ -180,6 +181,7 @@ func (r *EpollReactor) Run() {
 				handler.OnError(fd, errors.New("kqueue error"))
 			}
 		}
+		r.wheel.advance()
 	}
 }
 
@@ -38,6 +38,7 @@ type IOCPReactor struct {
 	handlers map[int]AsyncIOHandler
 	mu       sync.RWMutex
 	running  int32
+	wheel    wheelHolder
 
 	// 操作池，避免频繁分配
 	opPool sync.Pool
@@ -236,7 +237,8 @@ func (r *EpollReactor) Run() {
 		var overlapped *windows.Overlapped
 
 		// 等待I/O完成事件
-		err := windows.GetQueuedCompletionStatus(windows.Handle(r.iocp), &bytesTransferred, &completionKey, &overlapped, 100)
+		err := windows.GetQueuedCompletionStatus(windows.Handle(r.iocp), &bytesTransferred, &completionKey, &overlapped, uint32(r.wheel.waitMillis(100)))
+		r.wheel.advance()
 
 		if err != nil {
 			// 检查是否是超时错误
@@ -0,0 +1,240 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+// AuthenticatedEvent 登录成功，Payload为*Principal，在处理这个peer的处理器上同步执行，
+// 之后这个peer的消息才会到达处理函数
+var AuthenticatedEvent int32 = 8
+
+var (
+	// ErrAuthRequired 没有登录时收到了不允许的消息
+	ErrAuthRequired = errors.New("auth: login required")
+	// ErrAuthInProgress 上一次登录还没有结果
+	ErrAuthInProgress = errors.New("auth: login in progress")
+	// ErrAuthFailed Authenticator没有返回身份
+	ErrAuthFailed = errors.New("auth: login failed")
+	// ErrAuthTimeout 超过时间没有登录
+	ErrAuthTimeout = errors.New("auth: login timeout")
+	// ErrAuthAttempts 失败次数太多
+	ErrAuthAttempts = errors.New("auth: too many failed attempts")
+)
+
+// Authenticator 校验登录消息，在任务池中异步调用，返回nil身份等同于失败
+type Authenticator interface {
+	Authenticate(ctx context.Context, msg *Message) (*Principal, error)
+}
+
+// AuthenticatorFunc 函数形式的Authenticator
+type AuthenticatorFunc func(ctx context.Context, msg *Message) (*Principal, error)
+
+// Authenticate 实现Authenticator
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, msg *Message) (*Principal, error) {
+	return f(ctx, msg)
+}
+
+/*
+AuthStage 登录阶段，通过Processor.SetAuthStage开启，使用这个处理器的TCP、KCP、WebSocket服务器都生效
+
+  - 连接之后Timeout时间内没有登录成功就断开
+  - 登录前只有LoginIDs和AllowIDs中的消息被接受，LoginIDs交给Authenticator，
+    AllowIDs(例如心跳、版本检查)正常分发，其他消息直接拒绝，不会到达处理函数
+  - 同一个连接同时只有一个登录在进行，失败MaxAttempts次后断开
+  - 登录成功后身份绑定到peer的Session，peer.ID设置为身份的ID，然后执行AuthenticatedEvent
+  - 处理器的Peers按身份索引已登录的会话，重复登录按Duplicate处理：
+    检查和绑定在一个临界区内完成，由同一个处理器处理的被踢会话在AuthenticatedEvent之前断开，
+    处理器组中属于其他分片的会话投递到它自己的分片断开，可能在AuthenticatedEvent之后
+*/
+type AuthStage struct {
+	Authenticator Authenticator
+	LoginIDs      []int32
+	AllowIDs      []int32
+	// Timeout 连接后必须在这个时间内登录成功，0表示不限制
+	Timeout time.Duration
+	// MaxAttempts 最多失败次数，0表示不限制
+	MaxAttempts int
+	// CallTimeout 单次Authenticator调用的超时时间，默认10秒
+	CallTimeout time.Duration
+	// OnReject 消息被拒绝或者登录失败时调用，可以用来回复错误，msg可以为nil
+	// 可能在I/O goroutine或者处理器goroutine中调用
+	OnReject func(peer *ClientPeer, msg *Message, err error)
+	// Duplicate 同一个身份重复登录时的处理方式，MaxSessions为同时在线的会话数，默认1
+	Duplicate   DuplicatePolicy
+	MaxSessions int
+	// KickReply 被踢下线(ErrKicked)或者新登录被拒绝(ErrSessionLimit)时，
+	// 在断开之前发送给peer的原因消息，没有设置或者ok为false时发送SetCloseFrame的原因消息
+	KickReply func(peer *ClientPeer, err error) (id int32, body []byte, ok bool)
+
+	login map[int32]bool
+	allow map[int32]bool
+}
+
+// NewAuthStage 创建登录阶段，loginIDs是交给authenticator的登录消息
+func NewAuthStage(authenticator Authenticator, loginIDs ...int32) *AuthStage {
+	return &AuthStage{
+		Authenticator: authenticator,
+		LoginIDs:      loginIDs,
+		Timeout:       30 * time.Second,
+		MaxAttempts:   3,
+		CallTimeout:   10 * time.Second,
+	}
+}
+
+// Allow 添加登录前允许的消息
+func (s *AuthStage) Allow(ids ...int32) *AuthStage {
+	s.AllowIDs = append(s.AllowIDs, ids...)
+	return s
+}
+
+// SetAuthStage 开启登录阶段，nil表示关闭，之后修改stage不会生效
+func (p *Processor) SetAuthStage(stage *AuthStage) {
+	if stage != nil {
+		s := *stage
+		s.login = make(map[int32]bool, len(s.LoginIDs))
+		for _, id := range s.LoginIDs {
+			s.login[id] = true
+		}
+		s.allow = make(map[int32]bool, len(s.AllowIDs))
+		for _, id := range s.AllowIDs {
+			s.allow[id] = true
+		}
+		stage = &s
+	}
+	p.modifyHandlers(func(t *HandlerTable) error {
+		t.auth = stage
+		return nil
+	})
+}
+
+// reject 拒绝消息
+func (s *AuthStage) reject(peer *ClientPeer, msg *Message, err error) {
+	if s.OnReject != nil {
+		s.OnReject(peer, msg, err)
+	}
+}
+
+// admit 登录检查，pass为false时消息不再分发，accepted表示消息是否被接受
+func (p *Processor) admit(msg *Message) (pass, accepted bool) {
+	s := p.table().auth
+	peer := msg.Peer
+	if s == nil || peer == nil || peer.AsyncClientPeer == nil || peer.Session().Authenticated() {
+		return true, true
+	}
+	id := msg.Head.ID
+	if s.allow[id] {
+		return true, true
+	}
+	if s.login[id] {
+		return false, p.authenticate(s, msg)
+	}
+	base.Zap().Sugar().Debugf("message %d from conn %d rejected before login", id, peer.connID)
+	s.reject(peer, msg, ErrAuthRequired)
+	return false, false
+}
+
+// authenticate 在任务池中调用Authenticator，结果在处理这个peer的处理器上处理
+func (p *Processor) authenticate(s *AuthStage, msg *Message) bool {
+	peer := msg.Peer
+	if !atomic.CompareAndSwapInt32(&peer.authPending, 0, 1) {
+		s.reject(peer, msg, ErrAuthInProgress)
+		return false
+	}
+	// 消息体可能在投递之后被复用
+	login := &Message{Peer: peer, Head: msg.Head, Body: append([]byte(nil), msg.Body...)}
+	callTimeout := s.CallTimeout
+	if callTimeout <= 0 {
+		callTimeout = 10 * time.Second
+	}
+	p.GoWith(JobOptions{Peer: peer, Timeout: callTimeout}, func(ctx context.Context) (interface{}, error) {
+		return s.Authenticator.Authenticate(ctx, login)
+	}, func(result interface{}, err error) {
+		atomic.StoreInt32(&peer.authPending, 0)
+		if peer.GetState() != PeerStateConnected || peer.Session().Authenticated() {
+			return
+		}
+		principal, _ := result.(*Principal)
+		if err == nil && principal == nil {
+			err = ErrAuthFailed
+		}
+		if err != nil {
+			p.loginFailed(s, peer, login, err)
+			return
+		}
+		if !p.login(s, peer, principal) {
+			return
+		}
+		base.Zap().Sugar().Infof("conn %d authenticated as %d", peer.connID, principal.ID)
+		p.Owner(peer).handleEvent(&Event{
+			ID:      AuthenticatedEvent,
+			Peer:    peer,
+			Payload: principal,
+		})
+	})
+	return true
+}
+
+// login 检查重复登录并绑定身份，返回false表示新登录被拒绝
+// 其他分片上的会话投递到它的分片断开，不等待，避免两个分片互相等待
+func (p *Processor) login(s *AuthStage, peer *ClientPeer, principal *Principal) bool {
+	m := peer.manager
+	if m == nil {
+		peer.Session().Login(principal)
+		atomic.StoreInt64(&peer.ID, principal.ID)
+		return true
+	}
+	kicked, err := m.login(peer, principal, s.Duplicate, s.MaxSessions)
+	if err != nil {
+		base.Zap().Sugar().Warnf("conn %d login as %d rejected: %v", peer.connID, principal.ID, err)
+		s.reject(peer, nil, err)
+		sent := s.sendReason(peer, err)
+		peer.closeWith(&Disconnected{Reason: DisconnectKicked, Err: err}, !sent)
+		return false
+	}
+	owner := p.Owner(peer)
+	for _, e := range kicked {
+		e := e
+		if old := p.Owner(&ClientPeer{AsyncClientPeer: e.peer}); old != owner {
+			// 处理器组中属于其他分片的会话在它自己的分片上断开
+			err := old.Post(func() { s.kick(e.peer, e.session, ErrKicked) })
+			if err == nil {
+				continue
+			}
+			// 分片已经停止，不会再处理这个会话，直接断开
+			base.Zap().Sugar().Warnf("kick conn %d on its shard: %v", e.peer.connID, err)
+		}
+		s.kick(e.peer, e.session, ErrKicked)
+	}
+	return true
+}
+
+// loginFailed 登录失败，次数太多时断开
+func (p *Processor) loginFailed(s *AuthStage, peer *ClientPeer, msg *Message, err error) {
+	n := atomic.AddInt32(&peer.authAttempts, 1)
+	base.Zap().Sugar().Warnf("conn %d login failed (%d): %v", peer.connID, n, err)
+	s.reject(peer, msg, err)
+	if s.MaxAttempts > 0 && int(n) >= s.MaxAttempts {
+		s.reject(peer, nil, ErrAuthAttempts)
+		peer.closeWith(&Disconnected{Reason: DisconnectAuth, Err: ErrAuthAttempts}, true)
+	}
+}
+
+// startLogin 连接建立时开始计算登录超时，在处理器goroutine中调用
+func (p *Processor) startLogin(s *AuthStage, peer *ClientPeer) {
+	if s == nil || s.Timeout <= 0 || peer == nil || peer.AsyncClientPeer == nil {
+		return
+	}
+	p.PeerAfterFunc(peer, s.Timeout, func() {
+		if peer.GetState() != PeerStateConnected || peer.Session().Authenticated() {
+			return
+		}
+		base.Zap().Sugar().Warnf("conn %d login timeout", peer.connID)
+		s.reject(peer, nil, ErrAuthTimeout)
+		peer.closeWith(&Disconnected{Reason: DisconnectAuth, Err: ErrAuthTimeout}, true)
+	})
+}
@@ -0,0 +1,128 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAuthStage(t *testing.T) {
+	proc := NewProcessor()
+	const (
+		loginID int32 = 1
+		pingID  int32 = 2
+		gameID  int32 = 3
+	)
+	var (
+		mu       sync.Mutex
+		handled  []int32
+		rejected []error
+	)
+	for _, id := range []int32{loginID, pingID, gameID} {
+		proc.AddCallback(id, func(msg *Message) {
+			mu.Lock()
+			handled = append(handled, msg.Head.ID)
+			mu.Unlock()
+		})
+	}
+	authed := make(chan *Principal, 1)
+	SubscribePayload(proc, AuthenticatedEvent, func(e *Event, p *Principal) { authed <- p })
+
+	stage := NewAuthStage(AuthenticatorFunc(func(ctx context.Context, msg *Message) (*Principal, error) {
+		if string(msg.Body) != "secret" {
+			return nil, errors.New("bad password")
+		}
+		return &Principal{ID: 42}, nil
+	}), loginID).Allow(pingID)
+	stage.MaxAttempts = 2
+	stage.OnReject = func(peer *ClientPeer, msg *Message, err error) {
+		mu.Lock()
+		rejected = append(rejected, err)
+		mu.Unlock()
+	}
+	proc.SetAuthStage(stage)
+
+	go proc.StartProcess()
+	defer proc.Stop(context.Background(), StopNow)
+	waitRunning(proc)
+
+	peer := NewWebSocketClientPeer(&recordConn{}, proc)
+	post := func(id int32, body string) bool {
+		return proc.PostMessage(&Message{Peer: peer, Head: MessageHead{ID: id}, Body: []byte(body)})
+	}
+	if post(gameID, "") || !post(pingID, "") {
+		t.Fatal("unexpected pre-login admission")
+	}
+	if !post(loginID, "secret") {
+		t.Fatal("login rejected")
+	}
+	select {
+	case p := <-authed:
+		if p.ID != 42 || peer.ID != 42 || peer.Session().Principal() != p {
+			t.Fatalf("unexpected principal %+v", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not authenticated")
+	}
+	post(gameID, "")
+	Invoke(proc, context.Background(), func() (int, error) { return 0, nil })
+	for i := 0; i < 100; i++ {
+		mu.Lock()
+		n := len(handled)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	mu.Lock()
+	if len(handled) != 2 || handled[0] != pingID || handled[1] != gameID {
+		t.Fatalf("unexpected handled %v", handled)
+	}
+	if len(rejected) != 1 || rejected[0] != ErrAuthRequired {
+		t.Fatalf("unexpected rejects %v", rejected)
+	}
+	rejected = nil
+	mu.Unlock()
+
+	// 失败次数太多断开
+	other := NewWebSocketClientPeer(&recordConn{}, proc)
+	for i := 0; i < 2; i++ {
+		proc.PostMessage(&Message{Peer: other, Head: MessageHead{ID: loginID}, Body: []byte("wrong")})
+		for j := 0; j < 100 && atomic.LoadInt32(&other.authPending) == 1; j++ {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for i := 0; i < 100 && other.GetState() == PeerStateConnected; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if d := other.Disconnect(); d == nil || d.Reason != DisconnectAuth || d.Err != ErrAuthAttempts {
+		t.Fatalf("unexpected disconnect %+v", d)
+	}
+}
+
+func TestAuthTimeout(t *testing.T) {
+	proc := NewProcessor()
+	stage := NewAuthStage(AuthenticatorFunc(func(ctx context.Context, msg *Message) (*Principal, error) {
+		return nil, ErrAuthFailed
+	}), 1)
+	stage.Timeout = 20 * time.Millisecond
+	proc.SetAuthStage(stage)
+	go proc.StartProcess()
+	defer proc.Stop(context.Background(), StopNow)
+	waitRunning(proc)
+
+	peer := NewWebSocketClientPeer(&recordConn{}, proc)
+	proc.PostEvent(peer.ConnectedEvent())
+	for i := 0; i < 200 && peer.GetState() == PeerStateConnected; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if d := peer.Disconnect(); d == nil || d.Err != ErrAuthTimeout {
+		t.Fatalf("unexpected disconnect %+v", d)
+	}
+}
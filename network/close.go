@@ -0,0 +1,184 @@
+package network
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+// defaultCloseLinger 发送原因消息之后等待写出的默认时间
+const defaultCloseLinger = 200 * time.Millisecond
+
+// CloseFrame 构建断开前发送给peer的原因消息，ok为false时不发送
+type CloseFrame func(peer *ClientPeer, d *Disconnected) (id int32, body []byte, ok bool)
+
+// SetCloseFrame 设置CloseWithReason等断开前发送的原因消息，nil表示不发送
+// linger为等待原因消息写出的最长时间，0表示默认200毫秒，开启了SetFlushOnClose时使用它的设置
+// 只对TCP有效，WebSocket和KCP是同步写入
+func (p *Processor) SetCloseFrame(frame CloseFrame, linger time.Duration) {
+	p.modifyHandlers(func(t *HandlerTable) error {
+		t.closeFrame = frame
+		t.closeLinger = linger
+		return nil
+	})
+}
+
+// CloseWithReason 带原因关闭连接，code可以是内置的原因或者DisconnectUser之后的自定义代码
+// 设置了SetCloseFrame时先发送原因消息，等它写出(最多linger时间)再关闭
+// 原因会记录在日志和DisconnectStats中，并作为RemoveEvent的Payload
+func (peer *AsyncClientPeer) CloseWithReason(code DisconnectReason, text string) {
+	peer.closeWith(&Disconnected{Reason: code, Text: text}, true)
+}
+
+// closeWith 记录原因并关闭，frame为true时发送原因消息
+func (peer *AsyncClientPeer) closeWith(d *Disconnected, frame bool) {
+	if peer.GetState() != PeerStateConnected {
+		return
+	}
+	if !atomic.CompareAndSwapPointer(&peer.disconnect, nil, unsafe.Pointer(d)) {
+		d = peer.Disconnect()
+	}
+	proc := peer.getProcessor()
+	if !frame || proc == nil {
+		peer.Close()
+		return
+	}
+	t := proc.table()
+	if t.closeFrame == nil {
+		peer.Close()
+		return
+	}
+	client := &ClientPeer{AsyncClientPeer: peer}
+	if id, body, ok := t.closeFrame(client, d); ok {
+		if err := sendFrame(client, id, body); err != nil {
+			base.Zap().Sugar().Warnf("send close reason to conn %d: %v", peer.connID, err)
+		}
+	}
+	f := t.flush
+	if f == nil {
+		// 没有开启SetFlushOnClose时，只等待原因消息写出
+		linger := t.closeLinger
+		if linger <= 0 {
+			linger = defaultCloseLinger
+		}
+		f = &FlushOnClose{Linger: linger}
+	}
+	peer.closeFlush(f)
+}
+
+// defaultFlushLinger FlushOnClose.Linger为0时的等待时间
+const defaultFlushLinger = 5 * time.Second
+
+// FlushOnClose 关闭时先写完写队列，只对TCP有效，WebSocket和KCP是同步写入
+type FlushOnClose struct {
+	// Linger 等待写队列清空的最长时间，超时后丢弃剩下的数据，0表示默认5秒
+	Linger time.Duration
+	// ShutdownWrite 写完之后先半关闭(shutdown(SHUT_WR))，让对方读到EOF，再释放连接
+	ShutdownWrite bool
+}
+
+// SetFlushOnClose 开启关闭时写完写队列，nil表示关闭后立即释放，丢弃没有写出的数据
+// 关闭开始后peer不再接受新的发送，读到的消息也不再分发，写完或者超时之后才投递RemoveEvent
+func (p *Processor) SetFlushOnClose(f *FlushOnClose) {
+	if f != nil {
+		c := *f
+		f = &c
+	}
+	p.modifyHandlers(func(t *HandlerTable) error {
+		t.flush = f
+		return nil
+	})
+}
+
+// flushOnClose 处理器的FlushOnClose设置，没有开启时为nil
+func (peer *AsyncClientPeer) flushOnClose() *FlushOnClose {
+	if proc := peer.getProcessor(); proc != nil {
+		return proc.table().flush
+	}
+	return nil
+}
+
+// closeFlush 进入关闭状态，f不为nil时在后台写完写队列再释放，不阻塞调用者
+func (peer *AsyncClientPeer) closeFlush(f *FlushOnClose) {
+	if !atomic.CompareAndSwapInt32(&peer.state, int32(PeerStateConnected), int32(PeerStateClosing)) {
+		return // 已经在关闭或已关闭
+	}
+	peer.SetDisconnect(DisconnectLocal, nil)
+	w := peer.writer
+	if f == nil || peer.fd == -1 || w == nil || !w.busy() {
+		if f != nil && f.ShutdownWrite && peer.fd != -1 {
+			peer.shutdownWrite()
+		}
+		peer.release()
+		return
+	}
+	go peer.drain(w, f)
+}
+
+// drain 通过reactor写完写队列，fd在写完之前一直留在reactor中
+// 每次写入goroutine退出(写完或者等待可写)时检查一次，超时后停止写入再释放
+func (peer *AsyncClientPeer) drain(w *ZeroCopyMessageWriter, f *FlushOnClose) {
+	linger := f.Linger
+	if linger <= 0 {
+		linger = defaultFlushLinger
+	}
+	timer := time.NewTimer(linger)
+	defer timer.Stop()
+	w.tryAsyncWrite()
+	for w.busy() {
+		select {
+		case <-w.idle:
+		case <-timer.C:
+			// 先停止写入并等待正在进行的写入返回，release之后才关闭fd
+			base.Zap().Sugar().Warnf("conn %d close linger expired, %d writes dropped", peer.connID, w.discard())
+			peer.release()
+			return
+		}
+	}
+	if f.ShutdownWrite {
+		peer.shutdownWrite()
+	}
+	peer.release()
+}
+
+// shutdownWrite 半关闭连接的写方向
+func (peer *AsyncClientPeer) shutdownWrite() {
+	if cw, ok := peer.Connection.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err != nil {
+			base.Zap().Sugar().Debugf("shutdown write on conn %d: %v", peer.connID, err)
+		}
+	}
+}
+
+// closeStats 断开原因统计
+type closeStats struct {
+	mu       sync.Mutex
+	byReason map[DisconnectReason]uint64
+}
+
+func newCloseStats() *closeStats {
+	return &closeStats{byReason: make(map[DisconnectReason]uint64)}
+}
+
+// countDisconnect 记录一次断开
+func (p *Processor) countDisconnect(reason DisconnectReason) {
+	c := p.handlerOwner().closes
+	c.mu.Lock()
+	c.byReason[reason]++
+	c.mu.Unlock()
+}
+
+// DisconnectStats 按原因统计的断开次数，处理器组的入口和分片返回同样的统计
+func (p *Processor) DisconnectStats() map[DisconnectReason]uint64 {
+	c := p.handlerOwner().closes
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make(map[DisconnectReason]uint64, len(c.byReason))
+	for reason, n := range c.byReason {
+		stats[reason] = n
+	}
+	return stats
+}
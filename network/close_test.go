@@ -0,0 +1,91 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCloseWithReason(t *testing.T) {
+	proc := NewProcessor()
+	var got *Disconnected
+	proc.SetCloseFrame(func(peer *ClientPeer, d *Disconnected) (int32, []byte, bool) {
+		got = d
+		return 900, []byte(d.Text), true
+	}, 0)
+
+	conn := &recordConn{}
+	peer := NewWebSocketClientPeer(conn, proc)
+	maintenance := DisconnectUser + 1
+	peer.CloseWithReason(maintenance, "maintenance")
+	peer.CloseWithReason(DisconnectIdle, "ignored")
+
+	if peer.GetState() != PeerStateClosed {
+		t.Fatal("peer not closed")
+	}
+	if conn.count() != 1 {
+		t.Fatalf("expected one reason frame, got %d", conn.count())
+	}
+	if got == nil || got.Reason != maintenance || got.Text != "maintenance" {
+		t.Fatalf("unexpected frame reason %+v", got)
+	}
+	d, ok := Payload[*Disconnected](peer.LeaveEvent())
+	if !ok || d.Reason != maintenance || d.String() != "code 101: maintenance" {
+		t.Fatalf("unexpected disconnect %+v", d)
+	}
+
+	other := NewWebSocketClientPeer(&recordConn{}, proc)
+	other.Close()
+	stats := proc.DisconnectStats()
+	if stats[maintenance] != 1 || stats[DisconnectLocal] != 1 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+}
+
+func TestCloseReactorPeerPostsLeave(t *testing.T) {
+	proc := NewProcessor()
+	peer := NewWebSocketClientPeer(&recordConn{}, proc)
+	// 模拟已经注册到reactor的连接，服务器主动关闭时也要收到RemoveEvent
+	peer.started = 1
+	peer.CloseWithReason(DisconnectShutdown, "")
+	peer.Close()
+
+	select {
+	case e := <-proc.EventChan:
+		d, ok := Payload[*Disconnected](e)
+		if e.ID != RemoveEvent || !ok || d.Reason != DisconnectShutdown {
+			t.Fatalf("unexpected event %d %+v", e.ID, e.Payload)
+		}
+	default:
+		t.Fatal("remove event not posted")
+	}
+	if len(proc.EventChan) != 0 {
+		t.Fatal("remove event posted twice")
+	}
+}
+
+func TestCloseOnLoopWithFullEventQueue(t *testing.T) {
+	proc := NewProcessor(WithQueueCapacity(QueueEvent, 1))
+	peer := NewWebSocketClientPeer(&recordConn{}, proc)
+	peer.started = 1
+	removed := make(chan bool, 1)
+	proc.AddEventCallback(RemoveEvent, func(e *Event) { removed <- proc.OnLoop() })
+	proc.AddCallback(1, func(msg *Message) {
+		// 事件队列已满时在回调中关闭连接，不能等待自己的队列
+		proc.PostEvent(&Event{ID: 100})
+		msg.Peer.Close()
+	})
+	go proc.StartProcess()
+	defer proc.Stop(context.Background(), StopNow)
+	waitRunning(proc)
+
+	proc.PostMessage(&Message{Peer: peer, Head: MessageHead{ID: 1}})
+	select {
+	case onLoop := <-removed:
+		if !onLoop {
+			t.Fatal("remove event handled off the processor goroutine")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("processor deadlocked closing a peer")
+	}
+}
@@ -0,0 +1,88 @@
+//go:build !windows
+// +build !windows
+
+package network
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fillSendBuffer 发送直到写入停在EAGAIN
+func fillSendBuffer(t *testing.T, peer *AsyncClientPeer, n int) *PreparedMessage {
+	t.Helper()
+	pm, err := NewPreparedMessageFromBytes(30, bytes.Repeat([]byte{'x'}, 8192))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := peer.SendPrepared(pm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := peer.writer
+	waitFor(t, "send buffer full", func() bool { return atomic.LoadInt32(&w.blocked) == 1 })
+	return pm
+}
+
+func TestFlushOnClose(t *testing.T) {
+	proc := NewProcessor()
+	proc.SetFlushOnClose(&FlushOnClose{Linger: 5 * time.Second, ShutdownWrite: true})
+	peer, client := newSocketPeer(t, proc)
+	peer.started = 1
+
+	const n = 800
+	pm := fillSendBuffer(t, peer, n)
+	defer pm.Release()
+	peer.Close()
+	if peer.GetState() != PeerStateClosing {
+		t.Fatalf("unexpected state %d while flushing", peer.GetState())
+	}
+	if err := peer.SendMessageBuffer(pm.Bytes()); err == nil {
+		t.Fatal("send accepted while closing")
+	}
+
+	// 对方读到全部数据之后是EOF
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != n*pm.Len() {
+		t.Fatalf("flushed %d of %d bytes", len(got), n*pm.Len())
+	}
+	select {
+	case e := <-proc.EventChan:
+		if e.ID != RemoveEvent {
+			t.Fatalf("unexpected event %d", e.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("remove event not posted after flush")
+	}
+	waitFor(t, "peer released", func() bool { return peer.GetState() == PeerStateClosed })
+	if refs := atomic.LoadInt32(&pm.buffer.refs); refs != 1 {
+		t.Fatalf("unexpected refs %d", refs)
+	}
+}
+
+func TestFlushOnCloseLingerExpired(t *testing.T) {
+	proc := NewProcessor()
+	proc.SetFlushOnClose(&FlushOnClose{Linger: 50 * time.Millisecond})
+	peer, _ := newSocketPeer(t, proc)
+
+	// 对方一直不读，超时后丢弃剩下的数据，写入停止之后才释放
+	pm := fillSendBuffer(t, peer, 800)
+	defer pm.Release()
+	w := peer.writer
+	peer.Close()
+	waitFor(t, "peer released", func() bool { return peer.GetState() == PeerStateClosed })
+	if atomic.LoadInt32(&w.writing) != 0 || w.writeQueue.Size() != 0 || w.pending != nil {
+		t.Fatal("writer still active after release")
+	}
+	if refs := atomic.LoadInt32(&pm.buffer.refs); refs != 1 {
+		t.Fatalf("unexpected refs %d", refs)
+	}
+}
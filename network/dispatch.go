@@ -0,0 +1,104 @@
+package network
+
+/*
+DispatchMode 消息的分发方式，按消息ID设置，默认DispatchQueued
+
+  - DispatchInline：在收到消息的I/O goroutine中直接回调，适合ping这种便宜、不访问处理器状态的消息。
+    同一个peer的消息由同一个读goroutine按收到的顺序回调，和处理循环以及其他peer并发执行
+  - DispatchQueued：投递到处理循环，和ImmediateMode为false时一样。
+    同一个peer同一个通道(Lane)的消息按收到的顺序回调，不同通道之间按权重交错
+  - DispatchWorker：交给处理器的有界工作池，适合寻路这种耗CPU的消息，结果用PostResult投递回处理器。
+    同一个peer的消息按收到的顺序依次回调，前一个返回之后才开始下一个，不同peer并发执行；
+    没有peer的消息之间没有顺序保证。工作池满时按QueueMessage的策略处理
+
+不同分发方式的消息之间没有顺序保证，ImmediateMode只影响DispatchQueued的消息
+*/
+type DispatchMode int
+
+const (
+	// DispatchQueued 投递到处理循环
+	DispatchQueued DispatchMode = iota
+	// DispatchInline 在I/O goroutine中直接回调
+	DispatchInline
+	// DispatchWorker 在工作池中回调
+	DispatchWorker
+)
+
+// String 分发方式名称
+func (m DispatchMode) String() string {
+	switch m {
+	case DispatchQueued:
+		return "queued"
+	case DispatchInline:
+		return "inline"
+	case DispatchWorker:
+		return "worker"
+	}
+	return "unknown"
+}
+
+// SetDispatchMode 设置消息ID的分发方式，运行中也可以调用，之后收到的消息使用新的方式
+func (p *Processor) SetDispatchMode(id int32, mode DispatchMode) {
+	p.modifyHandlers(func(t *HandlerTable) error {
+		if mode == DispatchQueued {
+			delete(t.modes, id)
+		} else {
+			t.modes[id] = mode
+		}
+		return nil
+	})
+}
+
+// DispatchMode 消息ID的分发方式
+func (p *Processor) DispatchMode(id int32) DispatchMode {
+	return p.table().modes[id]
+}
+
+// WorkerPending 工作池中等待和执行中的任务数量
+func (p *Processor) WorkerPending() int {
+	return p.handlerOwner().workers.pending()
+}
+
+// dispatchKey 同一个key的消息在工作池中依次执行，没有peer时为0
+func dispatchKey(msg *Message) uint64 {
+	if msg.Peer == nil || msg.Peer.AsyncClientPeer == nil {
+		return 0
+	}
+	return msg.Peer.connID
+}
+
+// dispatch 按消息ID的分发方式处理，handled为false表示需要投递到处理循环
+func (p *Processor) dispatch(msg *Message, policy OverloadPolicy) (handled, ok bool) {
+	switch p.DispatchMode(msg.Head.ID) {
+	case DispatchInline:
+		p.HandleMessage(msg)
+		return true, true
+	case DispatchWorker:
+		return true, p.submitWorker(msg, policy)
+	}
+	return false, false
+}
+
+// submitWorker 把消息交给工作池，工作池满时按policy处理
+func (p *Processor) submitWorker(msg *Message, policy OverloadPolicy) bool {
+	target := p.routeMessage(msg)
+	if !target.accepting() {
+		return false
+	}
+	w := p.handlerOwner().workers
+	if w.submit(dispatchKey(msg), func() { target.HandleMessage(msg) }, policy == OverloadBlock, target.quitChan()) {
+		return true
+	}
+	// 工作池中的任务不能丢弃，DropOldest等同于DropNewest
+	p.dropMessage(msg)
+	if policy == OverloadDisconnect {
+		disconnectSender(msg.Peer)
+	}
+	return false
+}
+
+// PostResult 把函数投递到处理这个消息的处理器上执行，用于DispatchWorker的回调把结果交回处理器
+// 从不阻塞，处理器已停止时返回ErrProcessorStopped
+func (p *Processor) PostResult(msg *Message, f ProcFunction) error {
+	return p.routeMessage(msg).Post(f)
+}
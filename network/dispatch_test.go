@@ -0,0 +1,140 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatchModes(t *testing.T) {
+	proc := NewProcessor(WithWorkers(4, 64))
+	peers := []*ClientPeer{
+		NewWebSocketClientPeer(&recordConn{}, proc),
+		NewWebSocketClientPeer(&recordConn{}, proc),
+		NewWebSocketClientPeer(&recordConn{}, proc),
+	}
+	const count = 200
+
+	var (
+		mu       sync.Mutex
+		order    = make(map[string][]int)
+		active   = make(map[*ClientPeer]int)
+		parallel bool
+		results  int
+		inline   bool
+	)
+	record := func(name string, msg *Message) {
+		mu.Lock()
+		order[name] = append(order[name], int(msg.Body[0])<<8|int(msg.Body[1]))
+		mu.Unlock()
+	}
+	proc.AddCallback(1, func(msg *Message) {
+		inline = !proc.OnLoop()
+		record("inline", msg)
+	})
+	proc.AddCallback(2, func(msg *Message) {
+		if !proc.OnLoop() {
+			t.Error("queued message not on processor loop")
+		}
+		record("queued", msg)
+	})
+	proc.AddCallback(3, func(msg *Message) {
+		mu.Lock()
+		active[msg.Peer]++
+		if active[msg.Peer] > 1 {
+			parallel = true
+		}
+		mu.Unlock()
+		record(fmt.Sprintf("worker-%d", msg.Peer.connID), msg)
+		time.Sleep(time.Duration(msg.Body[1]%3) * 100 * time.Microsecond)
+		mu.Lock()
+		active[msg.Peer]--
+		mu.Unlock()
+		proc.PostResult(msg, func() {
+			if !proc.OnLoop() {
+				t.Error("result not on processor loop")
+			}
+			results++
+		})
+	})
+	proc.SetDispatchMode(1, DispatchInline)
+	proc.SetDispatchMode(3, DispatchWorker)
+	if proc.DispatchMode(2) != DispatchQueued || proc.DispatchMode(3) != DispatchWorker {
+		t.Fatal("unexpected dispatch mode")
+	}
+
+	go proc.StartProcess()
+	defer proc.Stop(context.Background(), StopNow)
+	waitRunning(proc)
+
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer *ClientPeer) {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				body := []byte{byte(i >> 8), byte(i)}
+				proc.PostMessageWait(&Message{Peer: peer, Head: MessageHead{ID: 3}, Body: body})
+			}
+		}(peer)
+	}
+	for i := 0; i < count; i++ {
+		body := []byte{byte(i >> 8), byte(i)}
+		proc.PostMessageWait(&Message{Peer: peers[0], Head: MessageHead{ID: 1}, Body: body})
+		proc.PostMessageWait(&Message{Peer: peers[0], Head: MessageHead{ID: 2}, Body: body})
+	}
+	wg.Wait()
+	if !inline {
+		t.Fatal("inline message handled on processor loop")
+	}
+
+	done := func() bool {
+		r, _ := Invoke(proc, context.Background(), func() (int, error) { return results, nil })
+		return r == count*len(peers) && proc.WorkerPending() == 0
+	}
+	for i := 0; i < 200 && !done(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !done() {
+		t.Fatalf("worker results missing, pending %d", proc.WorkerPending())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if parallel {
+		t.Fatal("messages of one peer handled in parallel")
+	}
+	if len(order) != 2+len(peers) {
+		t.Fatalf("unexpected handlers %d", len(order))
+	}
+	for name, seq := range order {
+		if len(seq) != count {
+			t.Fatalf("%s: got %d messages", name, len(seq))
+		}
+		for i, n := range seq {
+			if i != n {
+				t.Fatalf("%s: order broken at %d", name, i)
+			}
+		}
+	}
+}
+
+func TestDispatchWorkerOverload(t *testing.T) {
+	proc := NewProcessor(WithWorkers(1, 1))
+	release := make(chan struct{})
+	proc.AddCallback(1, func(msg *Message) { <-release })
+	proc.SetDispatchMode(1, DispatchWorker)
+
+	if !proc.PostMessage(&Message{Head: MessageHead{ID: 1}}) {
+		t.Fatal("first message rejected")
+	}
+	if proc.PostMessage(&Message{Head: MessageHead{ID: 1}}) {
+		t.Fatal("full worker pool accepted message")
+	}
+	if d := proc.Drops(); d.ByMessage[1] != 1 {
+		t.Fatalf("unexpected drops %+v", d)
+	}
+	close(release)
+}
@@ -0,0 +1,163 @@
+package network
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+// DuplicatePolicy 同一个身份重复登录时的处理方式
+type DuplicatePolicy int
+
+const (
+	// DuplicateKickOld 超过MaxSessions时踢掉最早登录的会话
+	DuplicateKickOld DuplicatePolicy = iota
+	// DuplicateRejectNew 超过MaxSessions时拒绝新的登录并断开新连接
+	DuplicateRejectNew
+	// DuplicateAllow 不限制
+	DuplicateAllow
+)
+
+var (
+	// ErrKicked 同一个身份在其他地方登录，被踢下线
+	ErrKicked = errors.New("auth: logged in elsewhere")
+	// ErrSessionLimit 同一个身份在线的会话太多，新登录被拒绝
+	ErrSessionLimit = errors.New("auth: too many sessions")
+)
+
+// principalEntry 已登录的会话，可恢复会话重连后peer会更新
+type principalEntry struct {
+	session *Session
+	peer    *AsyncClientPeer
+}
+
+// GetByPrincipal 身份对应的所有在线peer，按登录顺序，挂起中的可恢复会话不包括在内
+func (m *PeerManager) GetByPrincipal(id int64) []*ClientPeer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var peers []*ClientPeer
+	for _, e := range m.byPrincipal[id] {
+		if e.peer.GetState() == PeerStateConnected {
+			peers = append(peers, &ClientPeer{AsyncClientPeer: e.peer})
+		}
+	}
+	return peers
+}
+
+// SessionCount 身份已登录的会话数量，包括挂起中的可恢复会话
+func (m *PeerManager) SessionCount(id int64) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.byPrincipal[id])
+}
+
+// login 在一个临界区内检查重复登录并建立索引，返回需要踢掉的会话
+// 会话结束时(可恢复会话在宽限期之后)自动移出索引
+func (m *PeerManager) login(peer *ClientPeer, principal *Principal, policy DuplicatePolicy, max int) ([]*principalEntry, error) {
+	if max <= 0 {
+		max = 1
+	}
+	s := peer.Session()
+	m.mu.Lock()
+	entries := m.byPrincipal[principal.ID]
+	var kicked []*principalEntry
+	if policy != DuplicateAllow && len(entries) >= max {
+		if policy == DuplicateRejectNew {
+			m.mu.Unlock()
+			return nil, ErrSessionLimit
+		}
+		n := len(entries) - max + 1
+		kicked = append(kicked, entries[:n]...)
+		entries = entries[n:]
+	}
+	m.byPrincipal[principal.ID] = append(entries[:len(entries):len(entries)], &principalEntry{session: s, peer: peer.AsyncClientPeer})
+	// 和BindID一样，同一个ID索引到最后登录的peer
+	if old := atomic.LoadInt64(&peer.ID); m.byID[old] == peer.AsyncClientPeer {
+		delete(m.byID, old)
+	}
+	atomic.StoreInt64(&peer.ID, principal.ID)
+	if _, ok := m.byConn[peer.connID]; ok {
+		m.byID[principal.ID] = peer.AsyncClientPeer
+	}
+	m.mu.Unlock()
+
+	s.Login(principal)
+	s.OnClose(func(*ClientPeer) {
+		m.logout(principal.ID, s)
+	})
+	return kicked, nil
+}
+
+// logout 会话结束，移出索引
+func (m *PeerManager) logout(id int64, s *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := m.byPrincipal[id]
+	for i, e := range entries {
+		if e.session == s {
+			next := make([]*principalEntry, 0, len(entries)-1)
+			next = append(next, entries[:i]...)
+			next = append(next, entries[i+1:]...)
+			if len(next) == 0 {
+				delete(m.byPrincipal, id)
+			} else {
+				m.byPrincipal[id] = next
+			}
+			return
+		}
+	}
+}
+
+// resumed 可恢复会话转移到新连接
+func (m *PeerManager) resumed(s *Session, peer *AsyncClientPeer) {
+	principal := s.Principal()
+	if principal == nil {
+		return
+	}
+	m.mu.Lock()
+	for _, e := range m.byPrincipal[principal.ID] {
+		if e.session == s {
+			e.peer = peer
+		}
+	}
+	m.mu.Unlock()
+}
+
+// kick 发送原因消息后断开会话，挂起中的可恢复会话直接结束
+func (s *AuthStage) kick(peer *AsyncClientPeer, session *Session, err error) {
+	client := &ClientPeer{AsyncClientPeer: peer}
+	if peer.GetState() == PeerStateConnected {
+		base.Zap().Sugar().Infof("conn %d kicked: %v", peer.connID, err)
+		sent := s.sendReason(client, err)
+		session.discardResume()
+		peer.closeWith(&Disconnected{Reason: DisconnectKicked, Err: err}, !sent)
+		return
+	}
+	session.discardResume()
+}
+
+// sendReason 发送KickReply构建的原因消息，返回false表示没有发送，由SetCloseFrame的原因消息代替
+func (s *AuthStage) sendReason(peer *ClientPeer, err error) bool {
+	if s.KickReply == nil {
+		return false
+	}
+	id, body, ok := s.KickReply(peer, err)
+	if !ok {
+		return false
+	}
+	if e := sendFrame(peer, id, body); e != nil {
+		base.Zap().Sugar().Warnf("send reason to conn %d: %v", peer.connID, e)
+	}
+	return true
+}
+
+// sendFrame 发送一个消息帧
+func sendFrame(peer *ClientPeer, id int32, body []byte) error {
+	pm, err := NewPreparedMessageFromBytes(id, body)
+	if err != nil {
+		return err
+	}
+	defer pm.Release()
+	return peer.SendPrepared(pm)
+}
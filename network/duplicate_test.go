@@ -0,0 +1,138 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDuplicateLogin(t *testing.T) {
+	newProc := func(policy DuplicatePolicy, max int) *Processor {
+		proc := NewProcessor()
+		proc.ImmediateMode = true
+		stage := NewAuthStage(AuthenticatorFunc(func(ctx context.Context, msg *Message) (*Principal, error) {
+			return &Principal{ID: 1}, nil
+		}), 1)
+		stage.Duplicate = policy
+		stage.MaxSessions = max
+		stage.KickReply = func(peer *ClientPeer, err error) (int32, []byte, bool) {
+			return 900, []byte(err.Error()), true
+		}
+		proc.SetAuthStage(stage)
+		go proc.StartProcess()
+		t.Cleanup(func() { proc.Stop(context.Background(), StopNow) })
+		return proc
+	}
+	login := func(proc *Processor) (*ClientPeer, *recordConn) {
+		conn := &recordConn{}
+		peer := NewWebSocketClientPeer(conn, proc)
+		proc.PostMessage(&Message{Peer: peer, Head: MessageHead{ID: 1}})
+		for i := 0; i < 100 && !peer.Session().Authenticated() && peer.GetState() == PeerStateConnected; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		// 等登录结果在处理器上处理完
+		Invoke(proc, context.Background(), func() (bool, error) { return true, nil })
+		return peer, conn
+	}
+
+	// 踢掉旧的
+	proc := newProc(DuplicateKickOld, 1)
+	a, aConn := login(proc)
+	b, bConn := login(proc)
+	if a.GetState() == PeerStateConnected || b.GetState() != PeerStateConnected {
+		t.Fatal("old session not kicked")
+	}
+	if d := a.Disconnect(); d == nil || d.Reason != DisconnectKicked || d.Err != ErrKicked {
+		t.Fatalf("unexpected disconnect %+v", d)
+	}
+	if len(aConn.writes) != 1 || len(bConn.writes) != 0 {
+		t.Fatalf("unexpected reason frames %d %d", len(aConn.writes), len(bConn.writes))
+	}
+	if peers := proc.Peers.GetByPrincipal(1); len(peers) != 1 || peers[0].AsyncClientPeer != b.AsyncClientPeer {
+		t.Fatalf("unexpected index %v", peers)
+	}
+	if proc.Peers.GetByID(1).AsyncClientPeer != b.AsyncClientPeer {
+		t.Fatal("id not bound to new peer")
+	}
+
+	// 最多两个，拒绝新的
+	proc = newProc(DuplicateRejectNew, 2)
+	a, _ = login(proc)
+	b, _ = login(proc)
+	c, cConn := login(proc)
+	if a.GetState() != PeerStateConnected || b.GetState() != PeerStateConnected || c.GetState() == PeerStateConnected {
+		t.Fatal("new session not rejected")
+	}
+	if d := c.Disconnect(); d == nil || d.Err != ErrSessionLimit || len(cConn.writes) != 1 {
+		t.Fatalf("unexpected disconnect %+v", d)
+	}
+	if proc.Peers.SessionCount(1) != 2 {
+		t.Fatalf("unexpected session count %d", proc.Peers.SessionCount(1))
+	}
+	a.Close()
+	if proc.Peers.SessionCount(1) != 1 {
+		t.Fatal("closed session still indexed")
+	}
+	if c, _ = login(proc); c.GetState() != PeerStateConnected {
+		t.Fatal("login rejected after slot freed")
+	}
+}
+
+func TestDuplicateLoginStoppedShard(t *testing.T) {
+	group := NewProcessorGroup(2)
+	entry := group.Processor()
+	stage := NewAuthStage(AuthenticatorFunc(func(ctx context.Context, msg *Message) (*Principal, error) {
+		return nil, ErrAuthFailed
+	}), 1)
+	stage.Duplicate = DuplicateKickOld
+	entry.SetAuthStage(stage)
+
+	a := NewWebSocketClientPeer(&recordConn{}, entry)
+	b := NewWebSocketClientPeer(&recordConn{}, entry)
+	for group.ShardForPeer(b) == group.ShardForPeer(a) {
+		b = NewWebSocketClientPeer(&recordConn{}, entry)
+	}
+	if !entry.login(stage, a, &Principal{ID: 1}) {
+		t.Fatal("first login rejected")
+	}
+	// 旧会话的分片已经停止，投递失败时直接断开
+	if err := group.ShardForPeer(a).Stop(context.Background(), StopNow); err != nil {
+		t.Fatal(err)
+	}
+	if !entry.login(stage, b, &Principal{ID: 1}) {
+		t.Fatal("second login rejected")
+	}
+	if a.GetState() == PeerStateConnected {
+		t.Fatal("old session on a stopped shard not kicked")
+	}
+}
+
+func TestKickOnLoopWithFullEventQueue(t *testing.T) {
+	proc := NewProcessor(WithQueueCapacity(QueueEvent, 1))
+	stage := NewAuthStage(AuthenticatorFunc(func(ctx context.Context, msg *Message) (*Principal, error) {
+		return &Principal{ID: 1}, nil
+	}), 1)
+	peer := NewWebSocketClientPeer(&recordConn{}, proc)
+	peer.started = 1
+	removed := make(chan struct{})
+	proc.AddEventCallback(RemoveEvent, func(e *Event) { close(removed) })
+	go proc.StartProcess()
+	defer proc.Stop(context.Background(), StopNow)
+	waitRunning(proc)
+
+	// 登录结果在处理器上踢掉同一个处理器上的旧会话，事件队列满时不能等待自己
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := Invoke(proc, ctx, func() (bool, error) {
+		proc.EventChan <- &Event{ID: 100}
+		stage.kick(peer.AsyncClientPeer, peer.Session(), ErrKicked)
+		return true, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-removed:
+	case <-time.After(time.Second):
+		t.Fatal("remove event not handled")
+	}
+}
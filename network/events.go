@@ -0,0 +1,262 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+var (
+	// WriteBlockedEvent 写队列满，Payload为*WriteBlocked，写队列恢复之前只投递一次
+	WriteBlockedEvent int32 = 6
+	// ParseErrorEvent 收到的数据无法解析，Payload为*ParseError
+	ParseErrorEvent int32 = 7
+)
+
+// ErrWriteQueueFull 写队列满
+var ErrWriteQueueFull = errors.New("write queue full")
+
+// Connected AddEvent的Payload
+type Connected struct {
+	RemoteAddr net.Addr
+}
+
+// DisconnectReason 连接断开的原因
+type DisconnectReason int
+
+const (
+	// DisconnectUnknown 未知
+	DisconnectUnknown DisconnectReason = iota
+	// DisconnectLocal 服务器主动关闭
+	DisconnectLocal
+	// DisconnectRemote 对方关闭
+	DisconnectRemote
+	// DisconnectError 读写出错
+	DisconnectError
+	// DisconnectParseError 收到的数据无法解析
+	DisconnectParseError
+	// DisconnectAuth 登录超时或者失败次数太多
+	DisconnectAuth
+	// DisconnectKicked 同一个身份重复登录，被踢下线或者新登录被拒绝
+	DisconnectKicked
+	// DisconnectIdle 长时间没有活动
+	DisconnectIdle
+	// DisconnectShutdown 服务器停止
+	DisconnectShutdown
+
+	// DisconnectUser 应用自定义的原因从这里开始
+	DisconnectUser DisconnectReason = 100
+)
+
+// String 原因名称
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectLocal:
+		return "local"
+	case DisconnectRemote:
+		return "remote"
+	case DisconnectError:
+		return "error"
+	case DisconnectParseError:
+		return "parse error"
+	case DisconnectAuth:
+		return "auth"
+	case DisconnectKicked:
+		return "kicked"
+	case DisconnectIdle:
+		return "idle"
+	case DisconnectShutdown:
+		return "shutdown"
+	}
+	if r >= DisconnectUser {
+		return fmt.Sprintf("code %d", int(r))
+	}
+	return "unknown"
+}
+
+// Disconnected RemoveEvent和SuspendEvent的Payload
+type Disconnected struct {
+	Reason DisconnectReason
+	Text   string // CloseWithReason的说明，可以为空
+	Err    error  // 导致断开的错误，可以为nil
+}
+
+// String 用于日志
+func (d *Disconnected) String() string {
+	s := d.Reason.String()
+	if d.Text != "" {
+		s += ": " + d.Text
+	}
+	if d.Err != nil {
+		s += ": " + d.Err.Error()
+	}
+	return s
+}
+
+// WriteBlocked WriteBlockedEvent的Payload
+type WriteBlocked struct {
+	Pending uint64 // 写队列中等待的数量
+}
+
+// ParseError ParseErrorEvent的Payload
+type ParseError struct {
+	Err error
+}
+
+// Payload 取出事件的Payload，类型不对或者没有时返回false
+func Payload[T any](event *Event) (T, bool) {
+	v, ok := event.Payload.(T)
+	return v, ok
+}
+
+// Subscription 事件订阅，用于取消
+type Subscription struct {
+	proc *Processor
+	id   int32
+	fn   EventCallback
+}
+
+// Unsubscribe 取消订阅，可以重复调用，运行中也可以调用
+func (s *Subscription) Unsubscribe() {
+	s.proc.modifyHandlers(func(t *HandlerTable) error {
+		subs := t.subs[s.id]
+		for i, sub := range subs {
+			if sub == s {
+				next := make([]*Subscription, 0, len(subs)-1)
+				next = append(next, subs[:i]...)
+				next = append(next, subs[i+1:]...)
+				if len(next) == 0 {
+					delete(t.subs, s.id)
+				} else {
+					t.subs[s.id] = next
+				}
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// Subscribe 订阅事件，同一个事件可以有多个订阅，在AddEventCallback注册的回调之后按订阅顺序执行
+// 一个订阅panic不影响其他订阅
+func (p *Processor) Subscribe(id int32, fn EventCallback) *Subscription {
+	s := &Subscription{proc: p.handlerOwner(), id: id, fn: fn}
+	p.modifyHandlers(func(t *HandlerTable) error {
+		subs := t.subs[id]
+		t.subs[id] = append(subs[:len(subs):len(subs)], s)
+		return nil
+	})
+	return s
+}
+
+// SubscribePayload 订阅事件，只有Payload类型为T的事件才会回调
+func SubscribePayload[T any](p *Processor, id int32, fn func(event *Event, payload T)) *Subscription {
+	return p.Subscribe(id, func(event *Event) {
+		if v, ok := event.Payload.(T); ok {
+			fn(event, v)
+		}
+	})
+}
+
+// Publish 投递带Payload的事件，可以从任何goroutine向任何处理器投递
+// 队列满时按QueueEvent的策略处理，处理器组投递到第一个分片
+func (p *Processor) Publish(id int32, payload interface{}) bool {
+	return p.PostEvent(&Event{ID: id, Payload: payload})
+}
+
+// Forward 把这个处理器收到的事件转发给target，target上的订阅在target的goroutine中执行
+func (p *Processor) Forward(id int32, target *Processor) *Subscription {
+	return p.Subscribe(id, func(event *Event) {
+		e := *event
+		target.PostEvent(&e)
+	})
+}
+
+// callEvent 调用一个事件回调并recover
+func (p *Processor) callEvent(cb EventCallback, event *Event) {
+	defer p.recoverEvent(event)
+	cb(event)
+}
+
+// ConnectedEvent 连接建立后应该投递给处理器的事件
+func (peer *AsyncClientPeer) ConnectedEvent() *Event {
+	var addr net.Addr
+	if peer.Connection != nil {
+		addr = peer.Connection.RemoteAddr()
+	}
+	return &Event{
+		ID:      AddEvent,
+		Peer:    &ClientPeer{AsyncClientPeer: peer},
+		Payload: &Connected{RemoteAddr: addr},
+	}
+}
+
+// SetDisconnect 记录连接断开的原因，在Close之前调用，只有第一次记录有效，Close默认记录DisconnectLocal
+func (peer *AsyncClientPeer) SetDisconnect(reason DisconnectReason, err error) {
+	atomic.CompareAndSwapPointer(&peer.disconnect, nil, unsafe.Pointer(&Disconnected{Reason: reason, Err: err}))
+}
+
+// Disconnect 连接断开的原因，连接还没断开时返回nil
+func (peer *AsyncClientPeer) Disconnect() *Disconnected {
+	return (*Disconnected)(atomic.LoadPointer(&peer.disconnect))
+}
+
+// SetReadError 按读错误记录断开原因，EOF是对方关闭
+func (peer *AsyncClientPeer) SetReadError(err error) {
+	if errors.Is(err, io.EOF) {
+		peer.SetDisconnect(DisconnectRemote, nil)
+	} else {
+		peer.SetDisconnect(DisconnectError, err)
+	}
+}
+
+// leaveEvent 带断开原因的RemoveEvent或SuspendEvent
+func (peer *AsyncClientPeer) leaveEvent(id int32) *Event {
+	event := &Event{
+		ID:   id,
+		Peer: &ClientPeer{AsyncClientPeer: peer},
+	}
+	if d := peer.Disconnect(); d != nil {
+		event.Payload = d
+	}
+	return event
+}
+
+// ReportParseError 记录解析错误并投递ParseErrorEvent，传输层在关闭连接之前调用
+func (peer *AsyncClientPeer) ReportParseError(err error) {
+	base.Zap().Sugar().Warnf("message parse error on conn %d: %v", peer.connID, err)
+	postPeerEvent(peer, &Event{
+		ID:      ParseErrorEvent,
+		Peer:    &ClientPeer{AsyncClientPeer: peer},
+		Payload: &ParseError{Err: err},
+	})
+}
+
+// enqueueWrite 放入写队列，队列满时第一次投递WriteBlockedEvent，之后写入成功时重置
+// 发送者通常是处理器上的回调，通知只尝试放入事件队列，事件队列也满时放弃，下次写入失败时再试
+func (peer *AsyncClientPeer) enqueueWrite(req *writeRequest) error {
+	if atomic.LoadInt32(&peer.writer.stopped) == 1 {
+		return errors.New("connection is not connected")
+	}
+	if peer.writer.writeQueue.Push(unsafe.Pointer(req)) {
+		atomic.StoreInt32(&peer.writeBlocked, 0)
+		return nil
+	}
+	if atomic.CompareAndSwapInt32(&peer.writeBlocked, 0, 1) {
+		base.Zap().Sugar().Warnf("write queue full on conn %d", peer.connID)
+		event := &Event{
+			ID:      WriteBlockedEvent,
+			Peer:    &ClientPeer{AsyncClientPeer: peer},
+			Payload: &WriteBlocked{Pending: peer.writer.writeQueue.Size()},
+		}
+		if !tryPostPeerEvent(peer, event) {
+			atomic.StoreInt32(&peer.writeBlocked, 0)
+		}
+	}
+	return ErrWriteQueueFull
+}
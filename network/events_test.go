@@ -0,0 +1,129 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEventSubscribe(t *testing.T) {
+	proc := NewProcessor()
+	var trace []string
+	proc.AddEventCallback(100, func(e *Event) { trace = append(trace, "callback") })
+	first := proc.Subscribe(100, func(e *Event) { trace = append(trace, "first") })
+	proc.Subscribe(100, func(e *Event) { panic("boom") })
+	SubscribePayload(proc, 100, func(e *Event, n int) { trace = append(trace, "typed") })
+
+	proc.handleEvent(&Event{ID: 100, Payload: 1})
+	proc.handleEvent(&Event{ID: 100, Payload: "not int"})
+	first.Unsubscribe()
+	first.Unsubscribe()
+	proc.handleEvent(&Event{ID: 100, Payload: 2})
+
+	want := []string{"callback", "first", "typed", "callback", "first", "callback", "typed"}
+	if len(trace) != len(want) {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+	for i := range want {
+		if trace[i] != want[i] {
+			t.Fatalf("unexpected trace %v", trace)
+		}
+	}
+}
+
+func TestEventForward(t *testing.T) {
+	src, dst := NewProcessor(), NewProcessor()
+	got := make(chan string, 1)
+	SubscribePayload(dst, 200, func(e *Event, s string) {
+		if !dst.OnLoop() {
+			t.Error("forwarded event not on target loop")
+		}
+		got <- s
+	})
+	src.Forward(200, dst)
+	for _, p := range []*Processor{src, dst} {
+		go p.StartProcess()
+		defer p.Stop(context.Background(), StopNow)
+		waitRunning(p)
+	}
+	if !src.Publish(200, "hello") {
+		t.Fatal("publish failed")
+	}
+	select {
+	case s := <-got:
+		if s != "hello" {
+			t.Fatalf("unexpected payload %q", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not forwarded")
+	}
+}
+
+func TestTransportEvents(t *testing.T) {
+	proc := NewProcessor()
+	peer := NewWebSocketClientPeer(&recordConn{}, proc)
+	if _, ok := Payload[*Connected](peer.ConnectedEvent()); !ok {
+		t.Fatal("connected event without payload")
+	}
+
+	parseErr := errors.New("bad frame")
+	peer.ReportParseError(parseErr)
+	if e := <-proc.EventChan; e.ID != ParseErrorEvent {
+		t.Fatalf("unexpected event %d", e.ID)
+	} else if p, ok := Payload[*ParseError](e); !ok || p.Err != parseErr {
+		t.Fatalf("unexpected payload %+v", e.Payload)
+	}
+
+	// 第一次记录的原因有效，Close不会覆盖
+	peer.SetDisconnect(DisconnectParseError, parseErr)
+	peer.Close()
+	d, ok := Payload[*Disconnected](peer.LeaveEvent())
+	if !ok || d.Reason != DisconnectParseError || d.Err != parseErr {
+		t.Fatalf("unexpected disconnect %+v", d)
+	}
+
+	other := NewWebSocketClientPeer(&recordConn{}, proc)
+	other.Close()
+	if d := other.Disconnect(); d == nil || d.Reason != DisconnectLocal {
+		t.Fatalf("unexpected disconnect %+v", d)
+	}
+}
+
+func TestWriteBlockedOnLoop(t *testing.T) {
+	proc := NewProcessor(WithQueueCapacity(QueueEvent, 1))
+	peer := NewWebSocketClientPeer(&recordConn{}, proc)
+	peer.writer.writeQueue = NewRingBuffer(2)
+	go proc.StartProcess()
+	defer proc.Stop(context.Background(), StopNow)
+	waitRunning(proc)
+
+	// 写队列和事件队列都满时，回调中的发送不会等待处理器自己
+	full := func() error {
+		var err error
+		for i := 0; i < 4 && err == nil; i++ {
+			err = peer.enqueueWrite(&writeRequest{})
+		}
+		return err
+	}
+	_, err := Invoke(proc, context.Background(), func() (bool, error) {
+		proc.PostEvent(&Event{ID: 100})
+		if err := full(); err != ErrWriteQueueFull {
+			return false, err
+		}
+		// 通知被放弃，下次写入失败时再试
+		if atomic.LoadInt32(&peer.writeBlocked) != 0 {
+			return false, errors.New("dropped notification not reset")
+		}
+		<-proc.EventChan
+		full()
+		if e := <-proc.EventChan; e.ID != WriteBlockedEvent || atomic.LoadInt32(&peer.writeBlocked) != 1 {
+			return false, errors.New("write blocked event not posted after the event queue recovered")
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,260 @@
+package network
+
+import (
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+/*
+分组（频道）用于公会聊天、地图频道、观战等多播场景
+
+线程安全：Group和GroupManager的所有方法都可以在任意goroutine（任意Processor）中调用
+
+顺序保证：
+  - 同一个goroutine对同一个分组的多次Publish，每个成员按调用顺序收到消息
+  - 不同goroutine并发Publish同一个分组，成员之间看到的相对顺序不保证一致
+  - Publish使用调用时的成员快照，与之并发的Join/Leave可能收到也可能收不到这条消息
+  - peer关闭时自动离开所有分组
+*/
+
+// Group 命名的peer集合
+type Group struct {
+	name    string
+	mu      sync.RWMutex
+	members map[uint64]*groupMember // connID -> member
+}
+
+type groupMember struct {
+	peer   *AsyncClientPeer
+	hookID uint64
+}
+
+// NewGroup 创建分组
+func NewGroup(name string) *Group {
+	return &Group{
+		name:    name,
+		members: make(map[uint64]*groupMember),
+	}
+}
+
+// Name 分组名
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Join 加入分组，已经在分组中时返回false
+func (g *Group) Join(peer *ClientPeer) bool {
+	connID := peer.connID
+	g.mu.Lock()
+	if _, ok := g.members[connID]; ok {
+		g.mu.Unlock()
+		return false
+	}
+	member := &groupMember{peer: peer.AsyncClientPeer}
+	g.members[connID] = member
+	g.mu.Unlock()
+
+	// peer关闭时自动离开
+	hookID := peer.Session().OnClose(func(*ClientPeer) {
+		g.drop(connID, member)
+	})
+	g.mu.Lock()
+	member.hookID = hookID
+	g.mu.Unlock()
+	return true
+}
+
+// Leave 离开分组，不在分组中时返回false
+func (g *Group) Leave(peer *ClientPeer) bool {
+	g.mu.Lock()
+	member, ok := g.members[peer.connID]
+	if ok {
+		delete(g.members, peer.connID)
+	}
+	g.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if member.hookID != 0 {
+		peer.Session().RemoveCloseHook(member.hookID)
+	}
+	return true
+}
+
+// drop peer关闭时移除成员
+func (g *Group) drop(connID uint64, member *groupMember) {
+	g.mu.Lock()
+	if cur, ok := g.members[connID]; ok && cur == member {
+		delete(g.members, connID)
+	}
+	g.mu.Unlock()
+}
+
+// Contains 是否是分组成员
+func (g *Group) Contains(peer *ClientPeer) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, ok := g.members[peer.connID]
+	return ok
+}
+
+// Len 成员数量
+func (g *Group) Len() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.members)
+}
+
+// Members 成员快照
+func (g *Group) Members() []*ClientPeer {
+	g.mu.RLock()
+	peers := make([]*ClientPeer, 0, len(g.members))
+	for _, member := range g.members {
+		peers = append(peers, &ClientPeer{AsyncClientPeer: member.peer})
+	}
+	g.mu.RUnlock()
+	return peers
+}
+
+// clear 移除所有成员
+func (g *Group) clear() {
+	g.mu.Lock()
+	members := g.members
+	g.members = make(map[uint64]*groupMember)
+	g.mu.Unlock()
+	for _, member := range members {
+		if member.hookID != 0 {
+			member.peer.Session().RemoveCloseHook(member.hookID)
+		}
+	}
+}
+
+// Publish 向所有成员发送消息，消息只序列化一次，返回成功发送的数量
+func (g *Group) Publish(msg proto.Message, msgid int32) (int, error) {
+	pm, err := NewPreparedMessage(msg, msgid)
+	if err != nil {
+		return 0, err
+	}
+	defer pm.Release()
+	return g.PublishPrepared(pm, nil), nil
+}
+
+// PublishPrepared 向成员发送预先编码的消息，filter为nil时发送给所有成员
+func (g *Group) PublishPrepared(pm *PreparedMessage, filter PeerFilter) int {
+	sent := 0
+	for _, peer := range g.Members() {
+		if filter != nil && !filter(peer) {
+			continue
+		}
+		if err := peer.SendPrepared(pm); err != nil {
+			base.Zap().Sugar().Debugf("publish to group %s conn %d failed: %v", g.name, peer.connID, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// GroupManager 按名字管理分组
+type GroupManager struct {
+	mu     sync.RWMutex
+	groups map[string]*Group
+}
+
+// NewGroupManager 创建分组管理器
+func NewGroupManager() *GroupManager {
+	return &GroupManager{
+		groups: make(map[string]*Group),
+	}
+}
+
+// Get 获取分组，不存在时返回nil
+func (gm *GroupManager) Get(name string) *Group {
+	gm.mu.RLock()
+	defer gm.mu.RUnlock()
+	return gm.groups[name]
+}
+
+// GetOrCreate 获取分组，不存在时创建
+func (gm *GroupManager) GetOrCreate(name string) *Group {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	g, ok := gm.groups[name]
+	if !ok {
+		g = NewGroup(name)
+		gm.groups[name] = g
+	}
+	return g
+}
+
+// Remove 删除分组，所有成员离开
+func (gm *GroupManager) Remove(name string) {
+	gm.mu.Lock()
+	g, ok := gm.groups[name]
+	delete(gm.groups, name)
+	gm.mu.Unlock()
+	if ok {
+		g.clear()
+	}
+}
+
+// Names 所有分组名
+func (gm *GroupManager) Names() []string {
+	gm.mu.RLock()
+	defer gm.mu.RUnlock()
+	names := make([]string, 0, len(gm.groups))
+	for name := range gm.groups {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Join 加入分组，分组不存在时创建
+func (gm *GroupManager) Join(name string, peer *ClientPeer) bool {
+	return gm.GetOrCreate(name).Join(peer)
+}
+
+// Leave 离开分组
+func (gm *GroupManager) Leave(name string, peer *ClientPeer) bool {
+	if g := gm.Get(name); g != nil {
+		return g.Leave(peer)
+	}
+	return false
+}
+
+// LeaveAll 离开所有分组
+func (gm *GroupManager) LeaveAll(peer *ClientPeer) {
+	gm.mu.RLock()
+	groups := make([]*Group, 0, len(gm.groups))
+	for _, g := range gm.groups {
+		groups = append(groups, g)
+	}
+	gm.mu.RUnlock()
+	for _, g := range groups {
+		g.Leave(peer)
+	}
+}
+
+// GroupsOf peer所在的分组名
+func (gm *GroupManager) GroupsOf(peer *ClientPeer) []string {
+	gm.mu.RLock()
+	defer gm.mu.RUnlock()
+	var names []string
+	for name, g := range gm.groups {
+		if g.Contains(peer) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Publish 向分组发送消息，分组不存在时返回0
+func (gm *GroupManager) Publish(name string, msg proto.Message, msgid int32) (int, error) {
+	g := gm.Get(name)
+	if g == nil {
+		return 0, nil
+	}
+	return g.Publish(msg, msgid)
+}
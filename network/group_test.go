@@ -0,0 +1,36 @@
+package network
+
+import "testing"
+
+func TestGroup(t *testing.T) {
+	proc := NewProcessor()
+	gm := NewGroupManager()
+	c1, c2 := &recordConn{}, &recordConn{}
+	p1 := NewWebSocketClientPeer(c1, proc)
+	p2 := NewWebSocketClientPeer(c2, proc)
+
+	if !gm.Join("guild", p1) || !gm.Join("guild", p2) || gm.Join("guild", p1) {
+		t.Fatalf("unexpected join result")
+	}
+	gm.Join("map", p1)
+	if names := gm.GroupsOf(p1); len(names) != 2 {
+		t.Fatalf("groups of p1 = %v", names)
+	}
+
+	pm, _ := NewPreparedMessageFromBytes(1, []byte("hi"))
+	defer pm.Release()
+	if n := gm.Get("guild").PublishPrepared(pm, nil); n != 2 {
+		t.Fatalf("publish = %d", n)
+	}
+
+	p1.Close()
+	if gm.Get("guild").Contains(p1) || gm.Get("map").Len() != 0 {
+		t.Fatalf("closed peer still in group")
+	}
+	if !gm.Leave("guild", p2) || gm.Get("guild").Len() != 0 {
+		t.Fatalf("leave failed")
+	}
+	if c1.count() != 1 || c2.count() != 1 {
+		t.Fatalf("unexpected writes %d %d", c1.count(), c2.count())
+	}
+}
@@ -0,0 +1,239 @@
+package network
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// ErrHandlerVersion 替换处理函数时版本不匹配，说明期间有其他修改
+var ErrHandlerVersion = errors.New("handler table version mismatch")
+
+// HandlerSet 一组处理函数，用于整体替换
+type HandlerSet struct {
+	Messages  map[int32]MsgCallback
+	Events    map[int32]EventCallback
+	Unhandled MsgCallback // 未注册的消息处理
+	Policies  map[int32]Policy
+}
+
+// HandlerTable 不可变的处理函数表，修改时复制一份新的再原子替换，
+// 所以分发时不需要加锁，任何时候注册和替换都是安全的
+type HandlerTable struct {
+	version uint64
+	set     HandlerSet
+	// 经过中间件包装的消息处理函数
+	chains      map[int32]MsgCallback
+	middlewares []Middleware
+	ranges      []rangeMiddleware
+	lanes       map[int32]Lane
+	modes       map[int32]DispatchMode
+	subs        map[int32][]*Subscription
+	auth        *AuthStage
+	denyReply   DenyReply
+	audit       func(e *AuditEntry)
+	closeFrame  CloseFrame
+	closeLinger time.Duration
+	flush       *FlushOnClose
+}
+
+func newHandlerTable() *HandlerTable {
+	return &HandlerTable{
+		set: HandlerSet{
+			Messages: make(map[int32]MsgCallback),
+			Events:   make(map[int32]EventCallback),
+			Policies: make(map[int32]Policy),
+		},
+		chains: make(map[int32]MsgCallback),
+		lanes:  make(map[int32]Lane),
+		modes:  make(map[int32]DispatchMode),
+		subs:   make(map[int32][]*Subscription),
+	}
+}
+
+// Version 版本号，每次修改加1
+func (t *HandlerTable) Version() uint64 {
+	return t.version
+}
+
+// Message 消息处理函数，不包括中间件
+func (t *HandlerTable) Message(id int32) (MsgCallback, bool) {
+	cb, ok := t.set.Messages[id]
+	return cb, ok
+}
+
+// Policy 消息的权限要求
+func (t *HandlerTable) Policy(id int32) (Policy, bool) {
+	pol, ok := t.set.Policies[id]
+	return pol, ok
+}
+
+// Event 事件处理函数
+func (t *HandlerTable) Event(id int32) (EventCallback, bool) {
+	cb, ok := t.set.Events[id]
+	return cb, ok
+}
+
+// MessageIDs 已注册的消息ID，从小到大
+func (t *HandlerTable) MessageIDs() []int32 {
+	ids := make([]int32, 0, len(t.set.Messages))
+	for id := range t.set.Messages {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Set 处理函数的副本，修改之后可以传给SwapHandlers
+func (t *HandlerTable) Set() HandlerSet {
+	return t.set.clone()
+}
+
+func (s HandlerSet) clone() HandlerSet {
+	c := HandlerSet{
+		Messages:  make(map[int32]MsgCallback, len(s.Messages)),
+		Events:    make(map[int32]EventCallback, len(s.Events)),
+		Unhandled: s.Unhandled,
+		Policies:  make(map[int32]Policy, len(s.Policies)),
+	}
+	for id, cb := range s.Messages {
+		c.Messages[id] = cb
+	}
+	for id, cb := range s.Events {
+		c.Events[id] = cb
+	}
+	for id, pol := range s.Policies {
+		c.Policies[id] = pol
+	}
+	return c
+}
+
+// clone 复制一份用于修改，chains在install时重新构建
+func (t *HandlerTable) clone() *HandlerTable {
+	n := &HandlerTable{
+		version:     t.version,
+		set:         t.set.clone(),
+		middlewares: t.middlewares,
+		ranges:      t.ranges,
+		auth:        t.auth,
+		denyReply:   t.denyReply,
+		audit:       t.audit,
+		closeFrame:  t.closeFrame,
+		closeLinger: t.closeLinger,
+		flush:       t.flush,
+		lanes:       make(map[int32]Lane, len(t.lanes)),
+		modes:       make(map[int32]DispatchMode, len(t.modes)),
+		subs:        make(map[int32][]*Subscription, len(t.subs)),
+	}
+	for id, lane := range t.lanes {
+		n.lanes[id] = lane
+	}
+	for id, mode := range t.modes {
+		n.modes[id] = mode
+	}
+	for id, subs := range t.subs {
+		n.subs[id] = subs
+	}
+	return n
+}
+
+// table 当前的处理函数表，分片使用入口处理器的
+func (p *Processor) table() *HandlerTable {
+	return (*HandlerTable)(atomic.LoadPointer(&p.handlerOwner().handlers))
+}
+
+// Handlers 当前的处理函数表
+func (p *Processor) Handlers() *HandlerTable {
+	return p.table()
+}
+
+// HandlerVersion 当前处理函数表的版本
+func (p *Processor) HandlerVersion() uint64 {
+	return p.table().version
+}
+
+// modifyHandlers 复制当前表、修改之后原子替换，返回新表
+func (p *Processor) modifyHandlers(modify func(t *HandlerTable) error) (*HandlerTable, error) {
+	h := p.handlerOwner()
+	h.handlersMu.Lock()
+	defer h.handlersMu.Unlock()
+	cur := (*HandlerTable)(atomic.LoadPointer(&h.handlers))
+	next := cur.clone()
+	if err := modify(next); err != nil {
+		return cur, err
+	}
+	next.version = cur.version + 1
+	next.buildChains()
+	atomic.StorePointer(&h.handlers, unsafe.Pointer(next))
+	return next, nil
+}
+
+// UpdateHandlers 批量修改处理函数，所有修改一起生效，返回新的版本
+// 例如一次注册一个功能模块的所有消息，分发时不会看到只注册了一半的状态
+func (p *Processor) UpdateHandlers(update func(set *HandlerSet)) uint64 {
+	t, _ := p.modifyHandlers(func(t *HandlerTable) error {
+		update(&t.set)
+		if t.set.Messages == nil {
+			t.set.Messages = make(map[int32]MsgCallback)
+		}
+		if t.set.Events == nil {
+			t.set.Events = make(map[int32]EventCallback)
+		}
+		if t.set.Policies == nil {
+			t.set.Policies = make(map[int32]Policy)
+		}
+		return nil
+	})
+	return t.version
+}
+
+// SwapHandlers 整体替换处理函数，当前版本不是expect时返回ErrHandlerVersion，
+// expect为0时无条件替换；返回新的版本，中间件、消息通道、分发方式和订阅保持不变
+func (p *Processor) SwapHandlers(set HandlerSet, expect uint64) (uint64, error) {
+	set = set.clone()
+	t, err := p.modifyHandlers(func(t *HandlerTable) error {
+		if expect != 0 && t.version != expect {
+			return ErrHandlerVersion
+		}
+		t.set = set
+		return nil
+	})
+	return t.version, err
+}
+
+// adoptLegacy 把直接写入CallbackMap、EventCallback的回调复制到处理函数表并清空，
+// 启动时调用，分发时不再读取这两个map
+func (p *Processor) adoptLegacy() {
+	h := p.handlerOwner()
+	if len(h.CallbackMap) == 0 && len(h.EventCallback) == 0 {
+		return
+	}
+	h.UpdateHandlers(func(set *HandlerSet) {
+		for id, cb := range h.CallbackMap {
+			if _, ok := set.Messages[id]; !ok {
+				set.Messages[id] = cb
+			}
+		}
+		for id, cb := range h.EventCallback {
+			if _, ok := set.Events[id]; !ok {
+				set.Events[id] = cb
+			}
+		}
+	})
+	for id := range h.CallbackMap {
+		delete(h.CallbackMap, id)
+	}
+	for id := range h.EventCallback {
+		delete(h.EventCallback, id)
+	}
+}
+
+// SetUnhandledHandler 设置未注册消息的处理函数
+func (p *Processor) SetUnhandledHandler(cb MsgCallback) {
+	p.modifyHandlers(func(t *HandlerTable) error {
+		t.set.Unhandled = cb
+		return nil
+	})
+}
@@ -0,0 +1,136 @@
+package network
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandlerHotSwap(t *testing.T) {
+	proc := NewProcessor()
+	var v1, v2 int32
+	proc.AddCallback(1, func(msg *Message) { atomic.AddInt32(&v1, 1) })
+	version := proc.HandlerVersion()
+
+	go proc.StartProcess()
+	defer proc.Stop(context.Background(), StopNow)
+	waitRunning(proc)
+
+	// 运行中注册和分发同时进行
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := int32(100); i < 200; i++ {
+			proc.AddCallback(i, func(msg *Message) {})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			proc.PostMessageWait(&Message{Head: MessageHead{ID: 1}})
+		}
+	}()
+	wg.Wait()
+	for i := 0; i < 100 && atomic.LoadInt32(&v1) < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(proc.Handlers().MessageIDs()) != 101 {
+		t.Fatalf("unexpected handlers %v", proc.Handlers().MessageIDs())
+	}
+
+	// 旧版本不能替换
+	set := HandlerSet{Messages: map[int32]MsgCallback{1: func(msg *Message) { atomic.AddInt32(&v2, 1) }}}
+	if _, err := proc.SwapHandlers(set, version); err != ErrHandlerVersion {
+		t.Fatalf("expected version mismatch, got %v", err)
+	}
+	next, err := proc.SwapHandlers(set, proc.HandlerVersion())
+	if err != nil || next != proc.HandlerVersion() {
+		t.Fatalf("swap failed %d %v", next, err)
+	}
+	if _, ok := proc.Handlers().Message(100); ok {
+		t.Fatal("old handler still installed")
+	}
+	if err := proc.PostMessageWait(&Message{Head: MessageHead{ID: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && atomic.LoadInt32(&v2) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&v1) != 100 || atomic.LoadInt32(&v2) != 1 {
+		t.Fatalf("unexpected dispatch %d %d", v1, v2)
+	}
+}
+
+func TestHandlerTableSnapshot(t *testing.T) {
+	proc := NewProcessor()
+	proc.ImmediateMode = true
+	var trace []string
+	proc.Use(func(next MsgCallback) MsgCallback {
+		return func(msg *Message) {
+			trace = append(trace, "mw")
+			next(msg)
+		}
+	})
+	old := proc.Handlers()
+	proc.UpdateHandlers(func(set *HandlerSet) {
+		set.Messages[1] = func(msg *Message) { trace = append(trace, "a") }
+		set.Messages[2] = func(msg *Message) { trace = append(trace, "b") }
+	})
+	// 已经取出的表不受之后修改的影响
+	if len(old.MessageIDs()) != 0 || old.Version()+1 != proc.HandlerVersion() {
+		t.Fatalf("snapshot changed %v %d", old.MessageIDs(), proc.HandlerVersion())
+	}
+	proc.SetUnhandledHandler(func(msg *Message) { trace = append(trace, "unhandled") })
+	proc.SetMessageLane(2, LaneHigh)
+	proc.PostMessage(&Message{Head: MessageHead{ID: 1}})
+	proc.PostMessage(&Message{Head: MessageHead{ID: 2}})
+	proc.PostMessage(&Message{Head: MessageHead{ID: 3}})
+	want := []string{"mw", "a", "mw", "b", "mw", "unhandled"}
+	if len(trace) != len(want) {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+	for i := range want {
+		if trace[i] != want[i] {
+			t.Fatalf("unexpected trace %v", trace)
+		}
+	}
+	if proc.MessageLane(2) != LaneHigh || len(proc.Handlers().MessageIDs()) != 2 {
+		t.Fatal("lane or handlers not updated")
+	}
+
+}
+
+func TestLegacyCallbacks(t *testing.T) {
+	proc := NewProcessor()
+	got := make(chan string, 3)
+	proc.AddCallback(6, func(msg *Message) { got <- "table" })
+	proc.CallbackMap[5] = func(msg *Message) { got <- "legacy" }
+	proc.CallbackMap[6] = func(msg *Message) { got <- "shadowed" }
+	proc.EventCallback[100] = func(e *Event) { got <- "legacy event" }
+
+	// 启动时复制到处理函数表，表中已有的不覆盖
+	go proc.StartProcess()
+	defer proc.Stop(context.Background(), StopNow)
+	waitRunning(proc)
+	if _, ok := proc.Handlers().Message(5); !ok || len(proc.CallbackMap) != 0 || len(proc.EventCallback) != 0 {
+		t.Fatal("legacy callbacks not moved into the handler table")
+	}
+	proc.PostMessage(&Message{Head: MessageHead{ID: 5}})
+	proc.PostMessage(&Message{Head: MessageHead{ID: 6}})
+	proc.PostEvent(&Event{ID: 100})
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		select {
+		case s := <-got:
+			seen[s] = true
+		case <-time.After(time.Second):
+			t.Fatalf("callbacks not dispatched: %v", seen)
+		}
+	}
+	if !seen["legacy"] || !seen["table"] || !seen["legacy event"] {
+		t.Fatalf("unexpected dispatch %v", seen)
+	}
+}
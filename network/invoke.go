@@ -0,0 +1,162 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// goroutineID 当前goroutine的ID，只用于检测是否在处理器goroutine中
+// 运行时没有公开goroutine ID，这里解析runtime.Stack的第一行，每次调用需要几微秒，
+// 只在Invoke、Stop和处理循环启动时使用，不要放在每条消息的路径上
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	// "goroutine 123 [running]: ..."
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// OnLoop 当前是否在这个处理器的goroutine中，处理器组的入口检查第一个分片
+// 处理器没有运行时直接返回false，运行时需要解析调用栈，开销见goroutineID
+func (p *Processor) OnLoop() bool {
+	target := p.timerOwner(nil)
+	gid := atomic.LoadUint64(&target.loopGID)
+	return gid != 0 && gid == goroutineID()
+}
+
+// overflowQueue 函数队列满时的无界溢出队列
+type overflowQueue struct {
+	mu    sync.Mutex
+	funcs []ProcFunction
+	wake  chan struct{}
+	total uint64 // 累计溢出的数量
+}
+
+func newOverflowQueue() *overflowQueue {
+	return &overflowQueue{wake: make(chan struct{}, 1)}
+}
+
+// Post 投递函数到处理器goroutine执行，从不阻塞
+// FuncChan满时进入无界的溢出队列，之后的Post也进入溢出队列，保证Post之间的顺序
+// 处理器组的入口投递到第一个分片
+func (p *Processor) Post(f ProcFunction) error {
+	target := p.timerOwner(nil)
+	if !target.accepting() {
+		return ErrProcessorStopped
+	}
+	q := target.overflow
+	q.mu.Lock()
+	if len(q.funcs) == 0 {
+		select {
+		case target.FuncChan <- f:
+			q.mu.Unlock()
+			return nil
+		default:
+		}
+	}
+	q.funcs = append(q.funcs, f)
+	q.total++
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// OverflowLen 溢出队列中等待执行的数量
+func (p *Processor) OverflowLen() int {
+	q := p.timerOwner(nil).overflow
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.funcs)
+}
+
+// Overflowed 累计进入溢出队列的数量，持续增长说明FuncChan容量不够或者处理器过载
+func (p *Processor) Overflowed() uint64 {
+	q := p.timerOwner(nil).overflow
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.total
+}
+
+// runOverflow 执行溢出队列，先执行FuncChan中已有的函数，保证Post的顺序
+func (p *Processor) runOverflow() {
+	q := p.overflow
+	q.mu.Lock()
+	funcs := q.funcs
+	q.funcs = nil
+	q.mu.Unlock()
+	if len(funcs) == 0 {
+		return
+	}
+	for n := len(p.FuncChan); n > 0; n-- {
+		p.runFunc("func", <-p.FuncChan)
+	}
+	for _, f := range funcs {
+		p.runFunc("func", f)
+	}
+}
+
+// Invoke 在处理器goroutine中执行fn并返回结果，阻塞到执行完成、ctx取消或者处理器停止
+// 在处理器自己的goroutine中调用时直接执行，不会死锁；ctx取消时如果fn还没开始执行就不再执行
+// ImmediateMode不影响Invoke，fn总是在处理器goroutine中执行，处理器没有运行时等到ctx取消
+func Invoke[T any](p *Processor, ctx context.Context, fn func() (T, error)) (T, error) {
+	target := p.timerOwner(nil)
+	if target.OnLoop() {
+		return fn()
+	}
+	type result struct {
+		v   T
+		err error
+	}
+	var zero T
+	done := target.Done()
+	ch := make(chan result, 1)
+	err := target.Post(func() {
+		if ctx.Err() != nil {
+			return
+		}
+		var r result
+		defer func() {
+			if e := recover(); e != nil {
+				target.report(&PanicReport{
+					Kind:  "invoke",
+					Value: e,
+					Stack: debug.Stack(),
+					Time:  time.Now(),
+				})
+				r.err = fmt.Errorf("invoke panic: %v", e)
+			}
+			ch <- r
+		}()
+		r.v, r.err = fn()
+	})
+	if err != nil {
+		return zero, err
+	}
+	select {
+	case r := <-ch:
+		return r.v, r.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case <-done:
+		select {
+		case r := <-ch:
+			return r.v, r.err
+		default:
+			return zero, ErrProcessorStopped
+		}
+	}
+}
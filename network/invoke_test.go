@@ -0,0 +1,73 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProcessorInvoke(t *testing.T) {
+	proc := NewProcessor(WithQueueCapacity(QueueFunc, 1))
+	counter := 0
+
+	// 没有启动时Post不阻塞，溢出的部分按顺序执行
+	var order []int
+	for i := 0; i < 10; i++ {
+		i := i
+		if err := proc.Post(func() { order = append(order, i) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if proc.OverflowLen() != 9 || proc.Overflowed() != 9 {
+		t.Fatalf("unexpected overflow %d/%d", proc.OverflowLen(), proc.Overflowed())
+	}
+	go proc.StartProcess()
+	defer proc.Stop(context.Background(), StopNow)
+
+	v, err := Invoke(proc, context.Background(), func() (int, error) {
+		counter++
+		// 在处理器goroutine中再次调用直接执行
+		inner, err := Invoke(proc, context.Background(), func() (int, error) { return counter * 10, nil })
+		return inner, err
+	})
+	if err != nil || v != 10 {
+		t.Fatalf("unexpected invoke result %d %v", v, err)
+	}
+	for i, n := range order {
+		if i != n {
+			t.Fatalf("post order broken %v", order)
+		}
+	}
+	if len(order) != 10 {
+		t.Fatalf("posts not executed %v", order)
+	}
+
+	boom := errors.New("boom")
+	if _, err := Invoke(proc, context.Background(), func() (int, error) { return 0, boom }); err != boom {
+		t.Fatalf("expected boom, got %v", err)
+	}
+	if _, err := Invoke(proc, context.Background(), func() (int, error) { panic("invoke") }); err == nil {
+		t.Fatalf("panic should be returned as error")
+	}
+
+	// ctx取消时不再等待，也不再执行
+	block := make(chan struct{})
+	proc.Post(func() { <-block })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ran := false
+	if _, err := Invoke(proc, ctx, func() (bool, error) { ran = true; return true, nil }); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline, got %v", err)
+	}
+	close(block)
+	if _, err := Invoke(proc, context.Background(), func() (bool, error) { return ran, nil }); err != nil || ran {
+		t.Fatalf("cancelled invoke should not run")
+	}
+
+	// ImmediateMode时也在处理器goroutine中执行
+	proc.ImmediateMode = true
+	if onLoop, err := Invoke(proc, context.Background(), func() (bool, error) { return proc.OnLoop(), nil }); err != nil || !onLoop {
+		t.Fatalf("invoke ran on the caller goroutine in ImmediateMode")
+	}
+}
@@ -0,0 +1,201 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+// 默认任务池：读数据库、调用HTTP这类慢I/O任务，goroutine数量比CPU多
+const (
+	defaultJobWorkers  = 64
+	defaultJobCapacity = 4096
+)
+
+// Job 在任务池中执行的慢任务，需要检查ctx，超时或者取消之后尽快返回
+type Job func(ctx context.Context) (interface{}, error)
+
+// JobDone 任务完成的回调，总是在处理器goroutine中执行，ImmediateMode也一样
+type JobDone func(result interface{}, err error)
+
+// JobOptions 任务选项
+type JobOptions struct {
+	// Key 同一个key的任务按提交顺序依次执行，例如一个玩家的所有存档，0表示不需要顺序
+	Key uint64
+	// Peer 不为nil时done在处理这个peer的处理器上执行，Key为0时使用peer的连接作为key
+	Peer *ClientPeer
+	// Timeout 超时时间，0表示不超时
+	Timeout time.Duration
+	// Context 任务的上级context，可以为nil
+	Context context.Context
+}
+
+// JobHandle 已提交的任务
+type JobHandle struct {
+	cancel   context.CancelFunc
+	timer    unsafe.Pointer // *time.Timer，超时时结束任务，不等job返回
+	finished int32
+	finish   func(result interface{}, err error)
+}
+
+// Cancel 取消任务，还没开始执行的任务不再执行，done收到context.Canceled
+// 正在执行的任务由job自己检查ctx，done不会再收到job的结果
+func (h *JobHandle) Cancel() {
+	h.cancel()
+	h.finish(nil, context.Canceled)
+}
+
+// Finished done是否已经投递
+func (h *JobHandle) Finished() bool {
+	return atomic.LoadInt32(&h.finished) != 0
+}
+
+// jobSet 已提交还没开始执行的任务，任务池停止时这些任务以ErrProcessorStopped结束
+type jobSet struct {
+	mu      sync.Mutex
+	handles map[*JobHandle]struct{}
+}
+
+func newJobSet() *jobSet {
+	return &jobSet{handles: make(map[*JobHandle]struct{})}
+}
+
+func (s *jobSet) add(h *JobHandle) {
+	s.mu.Lock()
+	s.handles[h] = struct{}{}
+	s.mu.Unlock()
+}
+
+func (s *jobSet) remove(h *JobHandle) {
+	s.mu.Lock()
+	delete(s.handles, h)
+	s.mu.Unlock()
+}
+
+// fail 结束所有还没开始执行的任务，留在任务池队列中的函数之后执行时直接返回
+func (s *jobSet) fail(err error) {
+	s.mu.Lock()
+	handles := s.handles
+	s.handles = make(map[*JobHandle]struct{})
+	s.mu.Unlock()
+	for h := range handles {
+		h.cancel()
+		h.finish(nil, err)
+	}
+}
+
+// WithJobPool 设置任务池的goroutine数量和最多等待执行的任务数量
+func WithJobPool(workers, capacity int) ProcessorOption {
+	return func(p *Processor) {
+		p.jobs = newWorkerPool(workers, capacity)
+	}
+}
+
+// Go 在任务池中执行job，完成后在处理器goroutine中执行done，从不阻塞
+func (p *Processor) Go(job Job, done JobDone) *JobHandle {
+	return p.GoWith(JobOptions{}, job, done)
+}
+
+// GoKey 同一个key的任务按提交顺序依次执行
+func (p *Processor) GoKey(key uint64, job Job, done JobDone) *JobHandle {
+	return p.GoWith(JobOptions{Key: key}, job, done)
+}
+
+// GoWith 按选项在任务池中执行job，done只执行一次，结果是下面之一
+//   - job的返回值
+//   - 超时：context.DeadlineExceeded，不等job返回，job之后的返回值被丢弃
+//   - 上级ctx取消：上级ctx的错误，还没开始的job不再执行，正在执行的job返回之后才收到
+//   - 任务池满：ErrQueueFull
+//   - 处理器停止时还没开始执行：ErrProcessorStopped
+//
+// done投递到处理器执行，处理器已经停止时done不执行，只记录日志
+func (p *Processor) GoWith(opts JobOptions, job Job, done JobDone) *JobHandle {
+	target := p.timerOwner(nil)
+	key := opts.Key
+	if opts.Peer != nil {
+		target = p.Owner(opts.Peer).timerOwner(nil)
+		if key == 0 {
+			key = dispatchKey(&Message{Peer: opts.Peer})
+		}
+	}
+	parent := opts.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	var ctx context.Context
+	h := &JobHandle{}
+	if opts.Timeout > 0 {
+		ctx, h.cancel = context.WithTimeout(parent, opts.Timeout)
+	} else {
+		ctx, h.cancel = context.WithCancel(parent)
+	}
+	h.finish = func(result interface{}, err error) {
+		if !atomic.CompareAndSwapInt32(&h.finished, 0, 1) {
+			return
+		}
+		h.cancel()
+		if t := (*time.Timer)(atomic.LoadPointer(&h.timer)); t != nil {
+			t.Stop()
+		}
+		if done == nil {
+			return
+		}
+		if e := target.Post(func() { done(result, err) }); e != nil {
+			base.Zap().Sugar().Warnf("job finished after processor stopped: %v", e)
+		}
+	}
+
+	// 超时不等job返回，上级ctx取消由job检查，不为每个任务启动等待的goroutine
+	if opts.Timeout > 0 {
+		t := time.AfterFunc(opts.Timeout, func() { h.finish(nil, context.DeadlineExceeded) })
+		atomic.StorePointer(&h.timer, unsafe.Pointer(t))
+	}
+
+	owner := p.handlerOwner()
+	owner.queuedJobs.add(h)
+	ok := owner.jobs.submit(key, func() {
+		owner.queuedJobs.remove(h)
+		if err := ctx.Err(); err != nil {
+			h.finish(nil, err)
+			return
+		}
+		result, err := p.runJob(ctx, job)
+		if e := parent.Err(); e != nil {
+			result, err = nil, e
+		}
+		h.finish(result, err)
+	}, false, nil)
+	if !ok {
+		owner.queuedJobs.remove(h)
+		base.Zap().Sugar().Warnf("job pool full, %d pending", owner.jobs.pending())
+		h.finish(nil, ErrQueueFull)
+	}
+	return h
+}
+
+// runJob 执行job，panic转换成错误并报告
+func (p *Processor) runJob(ctx context.Context, job Job) (result interface{}, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			p.report(&PanicReport{
+				Kind:  "job",
+				Value: e,
+				Stack: debug.Stack(),
+				Time:  time.Now(),
+			})
+			result, err = nil, fmt.Errorf("job panic: %v", e)
+		}
+	}()
+	return job(ctx)
+}
+
+// JobsPending 任务池中等待和执行中的任务数量
+func (p *Processor) JobsPending() int {
+	return p.handlerOwner().jobs.pending()
+}
@@ -0,0 +1,162 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProcessorGo(t *testing.T) {
+	proc := NewProcessor(WithJobPool(4, 64))
+	go proc.StartProcess()
+	defer proc.Stop(context.Background(), StopNow)
+	waitRunning(proc)
+
+	type result struct {
+		v      interface{}
+		err    error
+		onLoop bool
+	}
+	results := make(chan result, 64)
+	done := func(v interface{}, err error) {
+		results <- result{v, err, proc.OnLoop()}
+	}
+
+	// 同一个key依次执行
+	var order []int
+	for i := 0; i < 20; i++ {
+		i := i
+		proc.GoKey(7, func(ctx context.Context) (interface{}, error) {
+			time.Sleep(time.Duration(20-i) * 100 * time.Microsecond)
+			order = append(order, i)
+			return i, nil
+		}, done)
+	}
+	for i := 0; i < 20; i++ {
+		r := <-results
+		if !r.onLoop || r.err != nil || r.v.(int) != i {
+			t.Fatalf("unexpected result %d: %+v", i, r)
+		}
+	}
+	for i, n := range order {
+		if i != n {
+			t.Fatalf("key order broken %v", order)
+		}
+	}
+
+	// 超时不等job返回
+	release := make(chan struct{})
+	proc.GoWith(JobOptions{Key: 1, Timeout: 10 * time.Millisecond}, func(ctx context.Context) (interface{}, error) {
+		<-release
+		return "late", nil
+	}, done)
+	if r := <-results; !errors.Is(r.err, context.DeadlineExceeded) || r.v != nil {
+		t.Fatalf("expected timeout, got %+v", r)
+	}
+
+	// 排在后面的任务被取消，不再执行
+	ran := make(chan struct{}, 1)
+	h := proc.GoKey(1, func(ctx context.Context) (interface{}, error) {
+		ran <- struct{}{}
+		return nil, nil
+	}, done)
+	h.Cancel()
+	if r := <-results; r.err != context.Canceled || !h.Finished() {
+		t.Fatalf("expected cancel, got %+v", r)
+	}
+	close(release)
+	for proc.JobsPending() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-ran:
+		t.Fatal("cancelled job executed")
+	default:
+	}
+
+	// panic转换成错误
+	proc.Go(func(ctx context.Context) (interface{}, error) { panic("boom") }, done)
+	if r := <-results; r.err == nil || !r.onLoop {
+		t.Fatalf("expected panic error, got %+v", r)
+	}
+}
+
+func TestProcessorGoPoolFull(t *testing.T) {
+	proc := NewProcessor(WithJobPool(1, 1))
+	proc.ImmediateMode = true
+	go proc.StartProcess()
+	defer proc.Stop(context.Background(), StopNow)
+	release := make(chan struct{})
+	errs := make(chan error, 2)
+	job := func(ctx context.Context) (interface{}, error) {
+		<-release
+		return nil, nil
+	}
+	proc.Go(job, func(v interface{}, err error) { errs <- err })
+	proc.Go(job, func(v interface{}, err error) { errs <- err })
+	if err := <-errs; err != ErrQueueFull {
+		t.Fatalf("expected queue full, got %v", err)
+	}
+	close(release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// 停止后工作goroutine退出，重新启动后提交的任务照常执行
+	proc.Stop(context.Background(), StopDrain)
+	proc.jobs.mu.Lock()
+	stopped := proc.jobs.quit == nil
+	proc.jobs.mu.Unlock()
+	if !stopped {
+		t.Fatal("job pool not stopped with the processor")
+	}
+	go proc.StartProcess()
+	waitRunning(proc)
+	proc.Go(job, func(v interface{}, err error) { errs <- err })
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessorGoParentAndStop(t *testing.T) {
+	proc := NewProcessor(WithJobPool(1, 4))
+	go proc.StartProcess()
+	waitRunning(proc)
+	errs := make(chan error, 4)
+	done := func(v interface{}, err error) { errs <- err }
+	ran := make(chan struct{}, 4)
+	queued := func(ctx context.Context) (interface{}, error) {
+		ran <- struct{}{}
+		return nil, nil
+	}
+
+	// 上级ctx取消后，还没开始的任务不执行，done收到上级ctx的错误
+	release := make(chan struct{})
+	proc.Go(func(ctx context.Context) (interface{}, error) { <-release; return nil, nil }, done)
+	ctx, cancel := context.WithCancel(context.Background())
+	proc.GoWith(JobOptions{Context: ctx}, queued, done)
+	cancel()
+	close(release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+
+	// 处理器停止时还没开始的任务以ErrProcessorStopped结束
+	release = make(chan struct{})
+	defer close(release)
+	proc.Go(func(ctx context.Context) (interface{}, error) { <-release; return nil, nil }, nil)
+	h := proc.Go(queued, nil)
+	proc.Stop(context.Background(), StopNow)
+	if !h.Finished() {
+		t.Fatal("queued job not finished on stop")
+	}
+	select {
+	case <-ran:
+		t.Fatal("queued job executed after stop")
+	default:
+	}
+}
@@ -0,0 +1,198 @@
+package network
+
+import (
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+/*
+Lane 处理器的优先级通道
+
+  - LaneControl：所有事件(EventChan)和投递的函数(FuncChan)，AddEvent、RemoveEvent、ExitEvent都在这里
+  - LaneHigh、LaneNormal、LaneBulk：消息按ID分配，默认都在LaneNormal(MessageChan)
+  - 处理循环按权重轮流从各通道取出，一轮中每个通道最多处理权重个，
+    所以任何通道都不会被饿死，积压的普通消息也不会让断线和退出延迟太久
+*/
+type Lane int
+
+const (
+	// LaneControl 控制通道
+	LaneControl Lane = iota
+	// LaneHigh 高优先级消息
+	LaneHigh
+	// LaneNormal 普通消息
+	LaneNormal
+	// LaneBulk 大批量、可以延后的消息
+	LaneBulk
+	laneCount
+)
+
+var defaultLaneWeights = [laneCount]int{8, 4, 2, 1}
+
+// laneBudget 连续处理多少个之后回到主循环检查定时器和停止信号
+const laneBudget = 1024
+
+// String 通道名称
+func (l Lane) String() string {
+	switch l {
+	case LaneControl:
+		return "control"
+	case LaneHigh:
+		return "high"
+	case LaneNormal:
+		return "normal"
+	case LaneBulk:
+		return "bulk"
+	}
+	return "unknown"
+}
+
+// SetLaneCapacity 设置通道容量，必须在投递和StartProcess之前调用
+// LaneControl同时设置EventChan和FuncChan，处理器组的入口会设置所有分片
+func (p *Processor) SetLaneCapacity(lane Lane, capacity int) {
+	if p.group != nil {
+		for _, shard := range p.group.shards {
+			shard.SetLaneCapacity(lane, capacity)
+		}
+		p.group.aliasEntry()
+		return
+	}
+	switch lane {
+	case LaneControl:
+		p.EventChan = make(chan *Event, capacity)
+		p.FuncChan = make(chan ProcFunction, capacity)
+	case LaneHigh:
+		p.highChan = make(chan *Message, capacity)
+	case LaneNormal:
+		p.MessageChan = make(chan *Message, capacity)
+	case LaneBulk:
+		p.bulkChan = make(chan *Message, capacity)
+	}
+}
+
+// SetLaneWeight 设置通道每轮最多处理的数量，最小为1，必须在StartProcess之前调用
+func (p *Processor) SetLaneWeight(lane Lane, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	if p.group != nil {
+		for _, shard := range p.group.shards {
+			shard.SetLaneWeight(lane, weight)
+		}
+		return
+	}
+	p.laneWeights[lane] = weight
+}
+
+// SetMessageLane 把消息ID分配到通道，运行中也可以调用，之后投递的消息使用新的通道
+func (p *Processor) SetMessageLane(id int32, lane Lane) {
+	if lane == LaneControl {
+		// 控制通道只用于事件和函数，控制类消息使用LaneHigh
+		lane = LaneHigh
+	}
+	p.modifyHandlers(func(t *HandlerTable) error {
+		if lane == LaneNormal {
+			delete(t.lanes, id)
+		} else {
+			t.lanes[id] = lane
+		}
+		return nil
+	})
+}
+
+// MessageLane 消息ID所在的通道
+func (p *Processor) MessageLane(id int32) Lane {
+	if lane, ok := p.table().lanes[id]; ok {
+		return lane
+	}
+	return LaneNormal
+}
+
+// LaneLen 通道中等待处理的数量
+func (p *Processor) LaneLen(lane Lane) int {
+	switch lane {
+	case LaneControl:
+		return len(p.EventChan) + len(p.FuncChan)
+	case LaneHigh:
+		return len(p.highChan)
+	case LaneNormal:
+		return len(p.MessageChan)
+	case LaneBulk:
+		return len(p.bulkChan)
+	}
+	return 0
+}
+
+// messageChan 消息所在通道的channel
+func (p *Processor) messageChan(msg *Message) chan *Message {
+	switch p.MessageLane(msg.Head.ID) {
+	case LaneHigh:
+		return p.highChan
+	case LaneBulk:
+		return p.bulkChan
+	}
+	return p.MessageChan
+}
+
+// dispatchEvent 处理事件，返回true表示收到ExitEvent
+func (p *Processor) dispatchEvent(event *Event) bool {
+	if event.ID == ExitEvent {
+		base.Zap().Sugar().Infof("Processor exit : %s", event.Param)
+		return true
+	}
+	p.handleEvent(event)
+	return false
+}
+
+// serveOne 从通道中非阻塞地处理一个，返回是否处理了以及是否需要退出
+func (p *Processor) serveOne(lane Lane) (served, exit bool) {
+	var ch chan *Message
+	switch lane {
+	case LaneControl:
+		select {
+		case event := <-p.EventChan:
+			return true, p.dispatchEvent(event)
+		case f := <-p.FuncChan:
+			p.runFunc("func", f)
+			return true, false
+		default:
+			return false, false
+		}
+	case LaneHigh:
+		ch = p.highChan
+	case LaneNormal:
+		ch = p.MessageChan
+	case LaneBulk:
+		ch = p.bulkChan
+	}
+	select {
+	case msg := <-ch:
+		p.HandleMessage(msg)
+		return true, false
+	default:
+		return false, false
+	}
+}
+
+// serveLanes 按权重轮流处理各通道，直到都为空或者用完预算，返回true表示收到ExitEvent
+func (p *Processor) serveLanes() bool {
+	for budget := laneBudget; budget > 0; {
+		served := 0
+		for lane := Lane(0); lane < laneCount; lane++ {
+			for n := 0; n < p.laneWeights[lane]; n++ {
+				ok, exit := p.serveOne(lane)
+				if exit {
+					return true
+				}
+				if !ok {
+					break
+				}
+				served++
+			}
+		}
+		if served == 0 {
+			return false
+		}
+		budget -= served
+	}
+	return false
+}
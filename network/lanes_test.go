@@ -0,0 +1,63 @@
+package network
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProcessorLanes(t *testing.T) {
+	proc := NewProcessor()
+	proc.SetLaneCapacity(LaneBulk, 4096)
+	proc.SetMessageLane(2, LaneHigh)
+	proc.SetMessageLane(3, LaneBulk)
+	if proc.MessageLane(2) != LaneHigh || proc.MessageLane(3) != LaneBulk || proc.MessageLane(1) != LaneNormal {
+		t.Fatalf("unexpected lane assignment")
+	}
+
+	var order []int32
+	record := func(msg *Message) { order = append(order, msg.Head.ID) }
+	proc.AddCallback(1, record)
+	proc.AddCallback(2, record)
+	proc.AddCallback(3, record)
+	removed := -1
+	proc.AddEventCallback(RemoveEvent, func(*Event) { removed = len(order) })
+
+	for i := 0; i < 1000; i++ {
+		proc.PostMessage(&Message{Head: MessageHead{ID: 1}})
+		proc.PostMessage(&Message{Head: MessageHead{ID: 3}})
+	}
+	proc.PostMessage(&Message{Head: MessageHead{ID: 2}})
+	proc.PostEvent(&Event{ID: RemoveEvent})
+	if proc.LaneLen(LaneBulk) != 1000 || proc.LaneLen(LaneControl) != 1 {
+		t.Fatalf("unexpected lane lengths")
+	}
+	go proc.StartProcess()
+	waitRunning(proc)
+	if err := proc.Stop(context.Background(), StopDrain); err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 2001 {
+		t.Fatalf("drained %d messages", len(order))
+	}
+	// 控制通道不会排在积压的消息之后，断线事件不会被饿死
+	if removed < 0 || removed > 8 {
+		t.Fatalf("remove event handled after %d messages", removed)
+	}
+	high := -1
+	bulk := 0
+	for i, id := range order {
+		if id == 2 && high < 0 {
+			high = i
+		}
+		if id == 3 {
+			bulk++
+		}
+	}
+	if high < 0 || high > 8 {
+		t.Fatalf("high lane message handled at %d", high)
+	}
+	// 按权重，普通和批量都有处理
+	if bulk == 0 || len(order)-bulk-1 < bulk {
+		t.Fatalf("unexpected weighted share: %d bulk of %d", bulk, len(order))
+	}
+}
@@ -231,15 +231,23 @@ func (r *AsyncMessageReader) Release() {
 }
 
 // ZeroCopyMessageWriter 零拷贝消息写入器
+// 同一时间只有一个写入goroutine，发送缓冲区满时当前请求保留在pending，
+// 等reactor的可写通知(resume)再继续，不会重新排队也不会空转
 type ZeroCopyMessageWriter struct {
 	writeQueue *RingBuffer
 	writing    int32
+	blocked    int32         // 发送缓冲区满，等待可写通知
+	stopped    int32         // 已经停止，不再写入
+	wakes      uint32        // 可写通知的次数
+	pending    *writeRequest // 没有写完的请求，只在写入goroutine中访问
+	idle       chan struct{} // 写入goroutine退出时通知
 }
 
 // NewZeroCopyMessageWriter 创建零拷贝消息写入器
 func NewZeroCopyMessageWriter() *ZeroCopyMessageWriter {
 	return &ZeroCopyMessageWriter{
 		writeQueue: NewRingBuffer(1024),
+		idle:       make(chan struct{}, 1),
 	}
 }
 
@@ -287,7 +295,7 @@ func (w *ZeroCopyMessageWriter) WriteMessage(fd int, msg proto.Message, msgID in
 
 	if !w.writeQueue.Push(unsafe.Pointer(writeReq)) {
 		buffer.Release()
-		return errors.New("write queue full")
+		return ErrWriteQueueFull
 	}
 
 	// 尝试异步写入
@@ -302,25 +310,96 @@ type writeRequest struct {
 	offset int
 }
 
-// tryAsyncWrite 尝试异步写入
+// tryAsyncWrite 尝试异步写入，等待可写通知期间不写入
 func (w *ZeroCopyMessageWriter) tryAsyncWrite() {
+	if atomic.LoadInt32(&w.blocked) == 1 || atomic.LoadInt32(&w.stopped) == 1 {
+		return
+	}
 	if !atomic.CompareAndSwapInt32(&w.writing, 0, 1) {
 		return // 已经在写入中
 	}
+	go w.flush()
+}
 
-	go func() {
-		defer atomic.StoreInt32(&w.writing, 0)
-
-		for {
+// flush 写入队列直到为空或者发送缓冲区满
+func (w *ZeroCopyMessageWriter) flush() {
+	for atomic.LoadInt32(&w.stopped) == 0 {
+		if w.pending == nil {
 			ptr := w.writeQueue.Pop()
 			if ptr == nil {
 				break
 			}
+			w.pending = (*writeRequest)(ptr)
+		}
+		wakes := atomic.LoadUint32(&w.wakes)
+		if !w.doWrite(w.pending) {
+			atomic.StoreInt32(&w.blocked, 1)
+			w.done()
+			// 写入期间已经收到可写通知时，不会再有下一次通知
+			if atomic.LoadUint32(&w.wakes) != wakes {
+				w.unblock()
+			}
+			return
+		}
+		w.pending = nil
+	}
+	w.done()
+	// Pop返回nil之后、writing清零之前放入的请求
+	if w.writeQueue.Size() > 0 {
+		w.tryAsyncWrite()
+	}
+}
+
+// done 写入goroutine退出
+func (w *ZeroCopyMessageWriter) done() {
+	atomic.StoreInt32(&w.writing, 0)
+	select {
+	case w.idle <- struct{}{}:
+	default:
+	}
+}
 
-			req := (*writeRequest)(ptr)
-			w.doWrite(req)
+// resume 收到可写通知，继续写入
+func (w *ZeroCopyMessageWriter) resume() {
+	atomic.AddUint32(&w.wakes, 1)
+	w.unblock()
+}
+
+func (w *ZeroCopyMessageWriter) unblock() {
+	if atomic.CompareAndSwapInt32(&w.blocked, 1, 0) {
+		w.tryAsyncWrite()
+	}
+}
+
+// busy 写队列中还有数据、正在写入或者等待可写
+func (w *ZeroCopyMessageWriter) busy() bool {
+	return w.writeQueue.Size() > 0 || atomic.LoadInt32(&w.writing) == 1 || atomic.LoadInt32(&w.blocked) == 1
+}
+
+// discard 停止写入，等正在进行的写入返回之后释放没有写出的请求，返回释放的数量
+// 返回之后不会再有对fd的写入，可以安全地关闭fd
+func (w *ZeroCopyMessageWriter) discard() int {
+	atomic.StoreInt32(&w.stopped, 1)
+	for atomic.LoadInt32(&w.writing) == 1 {
+		<-w.idle
+	}
+	n := 0
+	if w.pending != nil {
+		w.pending.buffer.Release()
+		w.pending = nil
+		n++
+	}
+	for {
+		ptr := w.writeQueue.Pop()
+		if ptr == nil {
+			break
+		}
+		if req := (*writeRequest)(ptr); req.buffer != nil {
+			req.buffer.Release()
 		}
-	}()
+		n++
+	}
+	return n
 }
 
 // doWrite 执行实际写入 - 平台特定实现在message_unix.go和message_windows.go中
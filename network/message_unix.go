@@ -5,28 +5,27 @@ package network
 
 import (
 	"syscall"
-	"unsafe"
 
 	"github.com/liangpengcheng/qcontinuum/base"
 )
 
-// doWrite Unix版本的写入实现
-func (w *ZeroCopyMessageWriter) doWrite(req *writeRequest) {
-	defer req.buffer.Release()
-
+// doWrite Unix版本的写入实现，返回false表示发送缓冲区满，请求保留到下次可写
+// 写完或者出错时释放请求持有的引用
+func (w *ZeroCopyMessageWriter) doWrite(req *writeRequest) bool {
 	for req.offset < req.buffer.Len() {
 		n, err := syscall.Write(req.fd, req.buffer.Data()[req.offset:req.buffer.Len()])
 		if err != nil {
 			if err == syscall.EAGAIN {
-				// 暂时无法写入，重新加入队列
-				if !w.writeQueue.Push(unsafe.Pointer(req)) {
-					base.Zap().Sugar().Warnf("failed to requeue write request")
-				}
-				return
+				return false
+			}
+			if err == syscall.EINTR {
+				continue
 			}
 			base.Zap().Sugar().Warnf("write error: %v", err)
-			return
+			break
 		}
 		req.offset += n
 	}
+	req.buffer.Release()
+	return true
 }
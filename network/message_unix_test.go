@@ -0,0 +1,116 @@
+//go:build !windows
+// +build !windows
+
+package network
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newSocketPeer 本地TCP连接上的peer，发送缓冲区很小，对方不读时很快就会EAGAIN
+func newSocketPeer(t *testing.T, proc *Processor) (*AsyncClientPeer, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.(*net.TCPConn).SetReadBuffer(256 << 10)
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := server.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := -1
+	raw.Control(func(f uintptr) {
+		fd = int(f)
+		syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, 64<<10)
+	})
+
+	reactor, err := NewEpollReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go reactor.Run()
+	peer := NewWebSocketClientPeer(server, proc).AsyncClientPeer
+	peer.fd = fd
+	peer.reactor = reactor
+	if err := reactor.AddFd(fd, EpollOut|EpollET, peer); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		peer.Close()
+		client.Close()
+		reactor.Close()
+	})
+	return peer, client
+}
+
+// waitFor 等待条件成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWriterBackpressure(t *testing.T) {
+	peer, client := newSocketPeer(t, NewProcessor())
+	pm, err := NewPreparedMessageFromBytes(30, bytes.Repeat([]byte{'x'}, 8192))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Release()
+
+	const n = 800
+	for i := 0; i < n; i++ {
+		if err := peer.SendPrepared(pm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 对方不读，写入停在EAGAIN，等待可写通知，不占用goroutine空转
+	w := peer.writer
+	waitFor(t, "writer blocked", func() bool {
+		return atomic.LoadInt32(&w.blocked) == 1 && atomic.LoadInt32(&w.writing) == 0
+	})
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&w.blocked) != 1 || atomic.LoadInt32(&w.writing) != 0 {
+		t.Fatal("writer retried without a writable notification")
+	}
+	// 没有写出的请求仍然持有共享缓冲区的引用
+	if refs := atomic.LoadInt32(&pm.buffer.refs); refs <= 1 {
+		t.Fatalf("shared buffer released early, refs=%d", refs)
+	}
+
+	got := make([]byte, n*pm.Len())
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if !bytes.Equal(got[i*pm.Len():(i+1)*pm.Len()], pm.Bytes()) {
+			t.Fatalf("frame %d corrupted or out of order", i)
+		}
+	}
+	waitFor(t, "writer idle", func() bool { return !w.busy() })
+	if refs := atomic.LoadInt32(&pm.buffer.refs); refs != 1 {
+		t.Fatalf("unexpected refs %d after all writes", refs)
+	}
+}
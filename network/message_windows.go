@@ -7,8 +7,8 @@ import (
 	"github.com/liangpengcheng/qcontinuum/base"
 )
 
-// doWrite Windows版本的写入实现 - 使用IOCP异步写入
-func (w *ZeroCopyMessageWriter) doWrite(req *writeRequest) {
+// doWrite Windows版本的写入实现 - 使用IOCP异步写入，同步写入不会返回false
+func (w *ZeroCopyMessageWriter) doWrite(req *writeRequest) bool {
 	defer req.buffer.Release()
 
 	// 在Windows IOCP模式下，写入操作应该通过reactor的postWrite方法
@@ -16,15 +16,16 @@ func (w *ZeroCopyMessageWriter) doWrite(req *writeRequest) {
 	conn := getConnFromFd(req.fd)
 	if conn == nil {
 		base.Zap().Sugar().Warnf("connection not found for fd %d", req.fd)
-		return
+		return true
 	}
 
 	for req.offset < req.buffer.Len() {
 		n, err := conn.Write(req.buffer.Data()[req.offset:req.buffer.Len()])
 		if err != nil {
 			base.Zap().Sugar().Warnf("write error: %v", err)
-			return
+			return true
 		}
 		req.offset += n
 	}
+	return true
 }
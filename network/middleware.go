@@ -0,0 +1,58 @@
+package network
+
+// Middleware 消息中间件，包装下一个处理函数
+// 通过msg.Peer和msg.Head.ID可以拿到peer和消息ID，不调用next则消息不会继续分发
+type Middleware func(next MsgCallback) MsgCallback
+
+// rangeMiddleware 只作用于[from, to]范围内消息ID的中间件
+type rangeMiddleware struct {
+	from, to    int32
+	middlewares []Middleware
+}
+
+// Use 添加全局中间件，先添加的在外层
+// 无论ImmediateMode还是StartProcess循环，分发消息时都会执行同样的中间件链
+func (p *Processor) Use(mw ...Middleware) {
+	p.modifyHandlers(func(t *HandlerTable) error {
+		t.middlewares = append(t.middlewares[:len(t.middlewares):len(t.middlewares)], mw...)
+		return nil
+	})
+}
+
+// UseRange 添加只作用于[from, to]范围内消息ID的中间件，在全局中间件之后执行
+func (p *Processor) UseRange(from, to int32, mw ...Middleware) {
+	p.modifyHandlers(func(t *HandlerTable) error {
+		t.ranges = append(t.ranges[:len(t.ranges):len(t.ranges)], rangeMiddleware{
+			from:        from,
+			to:          to,
+			middlewares: mw,
+		})
+		return nil
+	})
+}
+
+// buildChain 为消息ID构建中间件链
+func (t *HandlerTable) buildChain(id int32, handler MsgCallback) MsgCallback {
+	chain := handler
+	for i := len(t.ranges) - 1; i >= 0; i-- {
+		rm := t.ranges[i]
+		if id < rm.from || id > rm.to {
+			continue
+		}
+		for j := len(rm.middlewares) - 1; j >= 0; j-- {
+			chain = rm.middlewares[j](chain)
+		}
+	}
+	for i := len(t.middlewares) - 1; i >= 0; i-- {
+		chain = t.middlewares[i](chain)
+	}
+	return chain
+}
+
+// buildChains 构建所有已注册消息的中间件链
+func (t *HandlerTable) buildChains() {
+	t.chains = make(map[int32]MsgCallback, len(t.set.Messages))
+	for id, cb := range t.set.Messages {
+		t.chains[id] = t.buildChain(id, cb)
+	}
+}
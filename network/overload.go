@@ -0,0 +1,319 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+// ErrQueueFull 队列满，按策略丢弃
+var ErrQueueFull = errors.New("processor queue full")
+
+// Queue 处理器的队列
+type Queue int
+
+const (
+	// QueueMessage 消息队列，包括LaneHigh、LaneNormal、LaneBulk
+	QueueMessage Queue = iota
+	// QueueEvent 事件队列
+	QueueEvent
+	// QueueFunc 函数队列
+	QueueFunc
+	queueCount
+)
+
+// String 队列名称
+func (q Queue) String() string {
+	switch q {
+	case QueueMessage:
+		return "message"
+	case QueueEvent:
+		return "event"
+	case QueueFunc:
+		return "func"
+	}
+	return "unknown"
+}
+
+// OverloadPolicy 队列满时的处理方式
+type OverloadPolicy int
+
+const (
+	// OverloadBlock 阻塞投递者直到有空间或者处理器停止
+	// 在目标处理器自己的goroutine中投递时不会等待自己，改为进入无界的溢出队列
+	OverloadBlock OverloadPolicy = iota
+	// OverloadDropNewest 丢弃新投递的
+	OverloadDropNewest
+	// OverloadDropOldest 丢弃队列中最早的，为新投递的腾出空间
+	OverloadDropOldest
+	// OverloadDisconnect 丢弃新投递的并断开发送者，没有peer时等同于OverloadDropNewest
+	OverloadDisconnect
+)
+
+// 默认策略：消息丢弃新的，所有传输层一致；事件和函数阻塞，不能丢失断线等控制事件
+var defaultOverload = [queueCount]OverloadPolicy{OverloadDropNewest, OverloadBlock, OverloadBlock}
+
+// ProcessorOption NewProcessor的选项
+type ProcessorOption func(p *Processor)
+
+// WithQueueCapacity 设置队列容量，QueueMessage设置所有消息通道
+func WithQueueCapacity(q Queue, capacity int) ProcessorOption {
+	return func(p *Processor) {
+		switch q {
+		case QueueMessage:
+			p.highChan = make(chan *Message, capacity)
+			p.MessageChan = make(chan *Message, capacity)
+			p.bulkChan = make(chan *Message, capacity)
+		case QueueEvent:
+			p.EventChan = make(chan *Event, capacity)
+		case QueueFunc:
+			p.FuncChan = make(chan ProcFunction, capacity)
+		}
+	}
+}
+
+// WithLaneCapacity 设置单个优先级通道的容量
+func WithLaneCapacity(lane Lane, capacity int) ProcessorOption {
+	return func(p *Processor) {
+		p.SetLaneCapacity(lane, capacity)
+	}
+}
+
+// WithOverloadPolicy 设置队列满时的处理方式
+func WithOverloadPolicy(q Queue, policy OverloadPolicy) ProcessorOption {
+	return func(p *Processor) {
+		p.overload[q] = policy
+	}
+}
+
+// dropStats 丢弃统计
+type dropStats struct {
+	queues [queueCount]uint64
+	mu     sync.Mutex
+	byMsg  map[int32]uint64
+}
+
+func newDropStats() *dropStats {
+	return &dropStats{byMsg: make(map[int32]uint64)}
+}
+
+// DropSnapshot 丢弃统计快照
+type DropSnapshot struct {
+	Messages  uint64
+	Events    uint64
+	Funcs     uint64
+	ByMessage map[int32]uint64 // 按消息ID
+}
+
+// Drops 因为队列满而丢弃的统计，处理器组的入口和分片返回同样的统计
+// 按peer的统计见ClientPeer.Dropped
+func (p *Processor) Drops() DropSnapshot {
+	d := p.handlerOwner().drops
+	s := DropSnapshot{
+		Messages: atomic.LoadUint64(&d.queues[QueueMessage]),
+		Events:   atomic.LoadUint64(&d.queues[QueueEvent]),
+		Funcs:    atomic.LoadUint64(&d.queues[QueueFunc]),
+	}
+	d.mu.Lock()
+	s.ByMessage = make(map[int32]uint64, len(d.byMsg))
+	for id, n := range d.byMsg {
+		s.ByMessage[id] = n
+	}
+	d.mu.Unlock()
+	return s
+}
+
+// ResetDrops 清空丢弃统计
+func (p *Processor) ResetDrops() {
+	d := p.handlerOwner().drops
+	for i := range d.queues {
+		atomic.StoreUint64(&d.queues[i], 0)
+	}
+	d.mu.Lock()
+	d.byMsg = make(map[int32]uint64)
+	d.mu.Unlock()
+}
+
+// Dropped 这个peer因为处理器过载被丢弃的消息和事件数量
+func (peer *AsyncClientPeer) Dropped() uint64 {
+	return atomic.LoadUint64(&peer.dropped)
+}
+
+func countPeerDrop(peer *ClientPeer) uint64 {
+	if peer == nil || peer.AsyncClientPeer == nil {
+		return 0
+	}
+	return atomic.AddUint64(&peer.dropped, 1)
+}
+
+// dropMessage 记录丢弃的消息
+func (p *Processor) dropMessage(msg *Message) {
+	d := p.handlerOwner().drops
+	atomic.AddUint64(&d.queues[QueueMessage], 1)
+	d.mu.Lock()
+	d.byMsg[msg.Head.ID]++
+	d.mu.Unlock()
+	if n := countPeerDrop(msg.Peer); n > 0 {
+		base.Zap().Sugar().Warnf("message queue full, dropping message %d from conn %d (%d dropped)",
+			msg.Head.ID, msg.Peer.connID, n)
+	} else {
+		base.Zap().Sugar().Warnf("message queue full, dropping message %d", msg.Head.ID)
+	}
+}
+
+// dropEvent 记录丢弃的事件
+func (p *Processor) dropEvent(event *Event) {
+	atomic.AddUint64(&p.handlerOwner().drops.queues[QueueEvent], 1)
+	countPeerDrop(event.Peer)
+	base.Zap().Sugar().Errorf("event queue full, dropping event %d", event.ID)
+}
+
+// disconnectSender 过载时断开发送者
+func disconnectSender(peer *ClientPeer) {
+	if peer != nil && peer.AsyncClientPeer != nil {
+		base.Zap().Sugar().Warnf("processor overloaded, disconnecting conn %d", peer.connID)
+		peer.Close()
+	}
+}
+
+// enqueueMessage 按策略把消息放入target的通道
+func (p *Processor) enqueueMessage(target *Processor, msg *Message, policy OverloadPolicy) bool {
+	ch := target.messageChan(msg)
+	select {
+	case ch <- msg:
+		return true
+	default:
+	}
+	switch policy {
+	case OverloadBlock:
+		if target.OnLoop() {
+			return target.Post(func() { target.HandleMessage(msg) }) == nil
+		}
+		select {
+		case ch <- msg:
+			return true
+		case <-target.quitChan():
+			return false
+		}
+	case OverloadDropOldest:
+		for {
+			select {
+			case old := <-ch:
+				p.dropMessage(old)
+			default:
+			}
+			select {
+			case ch <- msg:
+				return true
+			default:
+			}
+		}
+	case OverloadDisconnect:
+		p.dropMessage(msg)
+		disconnectSender(msg.Peer)
+		return false
+	default:
+		p.dropMessage(msg)
+		return false
+	}
+}
+
+// enqueueEvent 按策略把事件放入target的通道
+func (p *Processor) enqueueEvent(target *Processor, event *Event, policy OverloadPolicy) bool {
+	select {
+	case target.EventChan <- event:
+		return true
+	default:
+	}
+	switch policy {
+	case OverloadBlock:
+		if target.OnLoop() {
+			return target.Post(func() { target.overflowEvent(event) }) == nil
+		}
+		select {
+		case target.EventChan <- event:
+			return true
+		case <-target.quitChan():
+			return false
+		}
+	case OverloadDropOldest:
+		for {
+			select {
+			case old := <-target.EventChan:
+				p.dropEvent(old)
+			default:
+			}
+			select {
+			case target.EventChan <- event:
+				return true
+			default:
+			}
+		}
+	case OverloadDisconnect:
+		p.dropEvent(event)
+		disconnectSender(event.Peer)
+		return false
+	default:
+		p.dropEvent(event)
+		return false
+	}
+}
+
+// overflowEvent 执行进入溢出队列的事件，ExitEvent在当前函数返回后退出处理循环
+func (p *Processor) overflowEvent(event *Event) {
+	if event.ID == ExitEvent {
+		base.Zap().Sugar().Infof("Processor exit : %s", event.Param)
+		p.beginStop(StopNow)
+		return
+	}
+	p.handleEvent(event)
+}
+
+// PostFunc 投递函数到处理器goroutine执行，队列满时按QueueFunc的策略处理
+// 处理器组的入口投递到第一个分片
+func (p *Processor) PostFunc(f ProcFunction) error {
+	return p.timerOwner(nil).postFunc(f)
+}
+
+// postFunc 投递函数到这个处理器
+func (p *Processor) postFunc(f ProcFunction) error {
+	if !p.accepting() {
+		return ErrProcessorStopped
+	}
+	select {
+	case p.FuncChan <- f:
+		return nil
+	default:
+	}
+	switch p.overload[QueueFunc] {
+	case OverloadBlock:
+		if p.OnLoop() {
+			return p.Post(f)
+		}
+		select {
+		case p.FuncChan <- f:
+			return nil
+		case <-p.quitChan():
+			return ErrProcessorStopped
+		}
+	case OverloadDropOldest:
+		for {
+			select {
+			case <-p.FuncChan:
+				atomic.AddUint64(&p.handlerOwner().drops.queues[QueueFunc], 1)
+			default:
+			}
+			select {
+			case p.FuncChan <- f:
+				return nil
+			default:
+			}
+		}
+	default:
+		atomic.AddUint64(&p.handlerOwner().drops.queues[QueueFunc], 1)
+		base.Zap().Sugar().Errorf("func queue full, dropping func")
+		return ErrQueueFull
+	}
+}
@@ -0,0 +1,80 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestProcessorOverload(t *testing.T) {
+	post := func(proc *Processor, peer *ClientPeer, ids ...int32) {
+		for _, id := range ids {
+			proc.PostMessage(&Message{Peer: peer, Head: MessageHead{ID: id}})
+		}
+	}
+
+	// 丢弃新的
+	proc := NewProcessor(WithQueueCapacity(QueueMessage, 2))
+	peer := NewWebSocketClientPeer(&recordConn{}, proc)
+	post(proc, peer, 1, 2, 3, 3)
+	if d := proc.Drops(); d.Messages != 2 || d.ByMessage[3] != 2 || peer.Dropped() != 2 {
+		t.Fatalf("unexpected drops %+v, peer %d", d, peer.Dropped())
+	}
+	if m := <-proc.MessageChan; m.Head.ID != 1 {
+		t.Fatalf("drop newest kept %d", m.Head.ID)
+	}
+
+	// 丢弃旧的
+	proc = NewProcessor(WithQueueCapacity(QueueMessage, 2), WithOverloadPolicy(QueueMessage, OverloadDropOldest))
+	post(proc, nil, 1, 2, 3)
+	if d := proc.Drops(); d.Messages != 1 || d.ByMessage[1] != 1 {
+		t.Fatalf("unexpected drops %+v", d)
+	}
+	if m := <-proc.MessageChan; m.Head.ID != 2 {
+		t.Fatalf("drop oldest kept %d", m.Head.ID)
+	}
+
+	// 断开发送者
+	proc = NewProcessor(WithLaneCapacity(LaneNormal, 1), WithOverloadPolicy(QueueMessage, OverloadDisconnect))
+	peer = NewWebSocketClientPeer(&recordConn{}, proc)
+	post(proc, peer, 1, 2)
+	if peer.GetState() == PeerStateConnected || peer.Dropped() != 1 {
+		t.Fatalf("overloaded sender should be disconnected")
+	}
+
+	// 函数队列
+	proc = NewProcessor(WithQueueCapacity(QueueFunc, 1), WithOverloadPolicy(QueueFunc, OverloadDropNewest))
+	if proc.PostFunc(func() {}) != nil || proc.PostFunc(func() {}) != ErrQueueFull || proc.Drops().Funcs != 1 {
+		t.Fatalf("unexpected func queue behaviour")
+	}
+}
+
+func TestProcessorOverloadOnLoop(t *testing.T) {
+	proc := NewProcessor(WithQueueCapacity(QueueEvent, 1), WithQueueCapacity(QueueFunc, 1))
+	var events []int32
+	proc.AddEventCallback(100, func(e *Event) { events = append(events, e.ID) })
+	proc.AddEventCallback(101, func(e *Event) { events = append(events, e.ID) })
+	go proc.StartProcess()
+	waitRunning(proc)
+
+	// 在处理器自己的goroutine中队列已满时不会等待自己
+	done := make(chan bool, 1)
+	proc.FuncChan <- func() {
+		proc.PostEvent(&Event{ID: 100})
+		ok := proc.PostEvent(&Event{ID: 101})
+		proc.PostFunc(func() {})
+		done <- ok && proc.PostFunc(func() {}) == nil
+	}
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("post on the own loop rejected")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("processor blocked on its own queue")
+	}
+	proc.Stop(context.Background(), StopDrain)
+	if len(events) != 2 {
+		t.Fatalf("events lost: %v", events)
+	}
+}
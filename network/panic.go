@@ -0,0 +1,212 @@
+package network
+
+import (
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+// PanicPolicy 消息处理函数panic后对peer的处理方式
+type PanicPolicy int
+
+const (
+	// PanicIgnore 不处理peer
+	PanicIgnore PanicPolicy = iota
+	// PanicDisconnect 断开peer
+	PanicDisconnect
+	// PanicQuarantine 隔离peer，之后这个peer的消息都被丢弃，但连接保持
+	PanicQuarantine
+)
+
+// PanicReport panic报告
+type PanicReport struct {
+	Kind        string      // message, event, func, update, invoke, worker, job
+	MsgID       int32       // Kind为message时的消息ID
+	EventID     int32       // Kind为event时的事件ID
+	Peer        *ClientPeer // 相关的peer，可以为nil
+	PayloadSize int         // 消息体长度
+	Value       interface{} // recover的值
+	Stack       []byte
+	Time        time.Time
+	Disabled    bool // 这次panic导致消息处理函数被熔断
+}
+
+// defaultPanicHook 默认写日志
+func defaultPanicHook(r *PanicReport) {
+	base.Zap().Sugar().Errorf("%s handler panic: msg(%d) event(%d) payload(%d) %v\n%s",
+		r.Kind, r.MsgID, r.EventID, r.PayloadSize, r.Value, r.Stack)
+}
+
+// panicBreaker 消息处理函数熔断器，window内panic次数达到threshold时禁用处理函数
+type panicBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	panics    map[int32][]time.Time
+	disabled  map[int32]time.Time // 禁用的截止时间，零值表示一直禁用
+	count     int32               // 禁用的数量，为0时分发不需要加锁
+}
+
+func newPanicBreaker() *panicBreaker {
+	return &panicBreaker{
+		panics:   make(map[int32][]time.Time),
+		disabled: make(map[int32]time.Time),
+	}
+}
+
+// record 记录一次panic，返回true表示处理函数被禁用
+func (b *panicBreaker) record(id int32, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 {
+		return false
+	}
+	history := b.panics[id]
+	kept := history[:0]
+	for _, t := range history {
+		if now.Sub(t) < b.window {
+			kept = append(kept, t)
+		}
+	}
+	kept = append(kept, now)
+	if len(kept) < b.threshold {
+		b.panics[id] = kept
+		return false
+	}
+	delete(b.panics, id)
+	var until time.Time
+	if b.cooldown > 0 {
+		until = now.Add(b.cooldown)
+	}
+	b.disabled[id] = until
+	atomic.StoreInt32(&b.count, int32(len(b.disabled)))
+	return true
+}
+
+// isOpen 处理函数是否被禁用
+func (b *panicBreaker) isOpen(id int32, now time.Time) bool {
+	if atomic.LoadInt32(&b.count) == 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.disabled[id]
+	if !ok {
+		return false
+	}
+	if !until.IsZero() && now.After(until) {
+		delete(b.disabled, id)
+		atomic.StoreInt32(&b.count, int32(len(b.disabled)))
+		return false
+	}
+	return true
+}
+
+// reset 重新启用处理函数
+func (b *panicBreaker) reset(id int32) {
+	b.mu.Lock()
+	delete(b.disabled, id)
+	delete(b.panics, id)
+	atomic.StoreInt32(&b.count, int32(len(b.disabled)))
+	b.mu.Unlock()
+}
+
+// SetPanicBreaker 设置熔断：window时间内同一个消息处理函数panic达到threshold次后禁用，
+// cooldown之后自动恢复，cooldown为0时需要调用ResetPanicBreaker恢复，threshold为0时关闭熔断
+func (p *Processor) SetPanicBreaker(threshold int, window, cooldown time.Duration) {
+	b := p.handlerOwner().breaker
+	b.mu.Lock()
+	b.threshold = threshold
+	b.window = window
+	b.cooldown = cooldown
+	b.mu.Unlock()
+}
+
+// ResetPanicBreaker 重新启用被熔断的消息处理函数
+func (p *Processor) ResetPanicBreaker(id int32) {
+	p.handlerOwner().breaker.reset(id)
+}
+
+// HandlerDisabled 消息处理函数是否被熔断
+func (p *Processor) HandlerDisabled(id int32) bool {
+	return p.handlerOwner().breaker.isOpen(id, time.Now())
+}
+
+// report 发送panic报告
+func (p *Processor) report(r *PanicReport) {
+	hook := p.handlerOwner().PanicHook
+	if hook == nil {
+		hook = defaultPanicHook
+	}
+	defer func() {
+		// 报告函数本身panic时只写日志
+		if err := recover(); err != nil {
+			base.Zap().Sugar().Errorf("panic hook panic: %v", err)
+		}
+	}()
+	hook(r)
+}
+
+// recoverMessage 消息处理函数的recover，必须直接defer调用
+func (p *Processor) recoverMessage(msg *Message) {
+	err := recover()
+	if err == nil {
+		return
+	}
+	h := p.handlerOwner()
+	now := time.Now()
+	r := &PanicReport{
+		Kind:        "message",
+		MsgID:       msg.Head.ID,
+		Peer:        msg.Peer,
+		PayloadSize: len(msg.Body),
+		Value:       err,
+		Stack:       debug.Stack(),
+		Time:        now,
+		Disabled:    h.breaker.record(msg.Head.ID, now),
+	}
+	p.report(r)
+
+	if msg.Peer == nil || msg.Peer.AsyncClientPeer == nil {
+		return
+	}
+	switch h.PanicPolicy {
+	case PanicDisconnect:
+		msg.Peer.Close()
+	case PanicQuarantine:
+		msg.Peer.Session().Quarantine()
+	}
+}
+
+// recoverEvent 事件处理函数的recover，必须直接defer调用
+func (p *Processor) recoverEvent(event *Event) {
+	if err := recover(); err != nil {
+		p.report(&PanicReport{
+			Kind:    "event",
+			EventID: event.ID,
+			Peer:    event.Peer,
+			Value:   err,
+			Stack:   debug.Stack(),
+			Time:    time.Now(),
+		})
+	}
+}
+
+// runFunc 执行函数并recover
+func (p *Processor) runFunc(kind string, f ProcFunction) {
+	defer func() {
+		if err := recover(); err != nil {
+			p.report(&PanicReport{
+				Kind:  kind,
+				Value: err,
+				Stack: debug.Stack(),
+				Time:  time.Now(),
+			})
+		}
+	}()
+	f()
+}
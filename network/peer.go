@@ -20,6 +20,9 @@ const (
 	PeerStateClosed
 )
 
+// 连接ID生成器，进程内唯一
+var nextConnID uint64
+
 // AsyncClientPeer 异步客户端peer
 type AsyncClientPeer struct {
 	Connection   net.Conn
@@ -29,6 +32,15 @@ type AsyncClientPeer struct {
 	state        int32          // PeerState
 	redirectProc unsafe.Pointer // *Processor, 使用unsafe.Pointer实现无锁
 	Proc         *Processor
+	session      unsafe.Pointer // *Session，会话恢复时原子替换
+	connID       uint64
+	manager      *PeerManager
+	dropped      uint64         // 处理器过载时被丢弃的数量
+	disconnect   unsafe.Pointer // *Disconnected，断开的原因
+	writeBlocked int32          // 写队列满，已经投递了WriteBlockedEvent
+	authPending  int32          // 正在登录
+	authAttempts int32          // 登录失败次数
+	started      int32          // 已经注册到reactor，关闭时由Close投递RemoveEvent
 
 	// 异步I/O组件
 	reader  *AsyncMessageReader
@@ -50,9 +62,14 @@ func NewWebSocketClientPeer(conn net.Conn, proc *Processor) *ClientPeer {
 		ID:         0,
 		state:      int32(PeerStateConnected),
 		lastActive: time.Now().Unix(),
+		session:    unsafe.Pointer(NewSession()),
 		reader:     NewAsyncMessageReader(),
 		writer:     NewZeroCopyMessageWriter(),
 		reactor:    nil, // WebSocket不使用reactor
+		connID:     atomic.AddUint64(&nextConnID, 1),
+	}
+	if proc != nil {
+		proc.Peers.add(peer)
 	}
 
 	return &ClientPeer{AsyncClientPeer: peer}
@@ -92,9 +109,14 @@ func NewAsyncClientPeer(conn net.Conn, proc *Processor, reactor *EpollReactor) (
 		writer:     NewZeroCopyMessageWriter(),
 		reactor:    reactor,
 		lastActive: time.Now().Unix(),
+		session:    unsafe.Pointer(NewSession()),
+		connID:     atomic.AddUint64(&nextConnID, 1),
 	}
 
 	atomic.StoreInt32(&peer.state, int32(PeerStateConnected))
+	if proc != nil {
+		proc.Peers.add(peer)
+	}
 
 	return peer, nil
 }
@@ -109,26 +131,41 @@ func (peer *AsyncClientPeer) setState(state PeerState) {
 	atomic.StoreInt32(&peer.state, int32(state))
 }
 
-// CheckAfter 超时检查
+// Session 获取会话，peer关闭后依然可以访问，peer为nil时返回nil
+func (peer *AsyncClientPeer) Session() *Session {
+	if peer == nil {
+		return nil
+	}
+	return (*Session)(atomic.LoadPointer(&peer.session))
+}
+
+// ConnID 连接ID，进程内唯一
+func (peer *AsyncClientPeer) ConnID() uint64 {
+	return peer.connID
+}
+
+// CheckAfter 超时检查，ID仍然为0时断开，完整的登录流程见AuthStage
+// 使用处理器定时器，不再为每个peer启动goroutine，peer关闭时自动取消
 func (peer *AsyncClientPeer) CheckAfter(t time.Duration) {
-	go func() {
-		time.AfterFunc(t, func() {
-			if peer.Proc != nil && peer.ID == 0 {
-				peer.Proc.FuncChan <- func() {
-					base.Zap().Sugar().Warnf("auth timeout %v", peer.Connection)
-					peer.Close()
-				}
-			}
-		})
-	}()
+	if peer.Proc == nil {
+		return
+	}
+	peer.Proc.PeerAfterFunc(&ClientPeer{AsyncClientPeer: peer}, t, func() {
+		if atomic.LoadInt64(&peer.ID) == 0 {
+			base.Zap().Sugar().Warnf("auth timeout %v", peer.Connection)
+			peer.CloseWithReason(DisconnectAuth, "auth timeout")
+		}
+	})
 }
 
-// Close 关闭连接
+// Close 关闭连接，没有记录原因时为DisconnectLocal，需要说明原因时使用CloseWithReason
+// 处理器开启了SetFlushOnClose时，先写完写队列再释放连接
 func (peer *AsyncClientPeer) Close() {
-	if !atomic.CompareAndSwapInt32(&peer.state, int32(PeerStateConnected), int32(PeerStateClosing)) {
-		return // 已经在关闭或已关闭
-	}
+	peer.closeFlush(peer.flushOnClose())
+}
 
+// release 释放连接和资源，写队列中剩下的数据被丢弃
+func (peer *AsyncClientPeer) release() {
 	// 从reactor移除
 	if peer.reactor != nil && peer.fd != -1 {
 		peer.reactor.RemoveFd(peer.fd)
@@ -139,6 +176,15 @@ func (peer *AsyncClientPeer) Close() {
 		removeConnFromFd(peer.fd)
 	}
 
+	// 先停止写入，避免关闭后对已经被复用的fd写入
+	if peer.writer != nil {
+		if peer.Connection != nil && peer.fd != -1 {
+			// Windows上是同步写入，让正在进行的写入立即返回
+			peer.Connection.SetWriteDeadline(time.Now())
+		}
+		peer.writer.discard()
+	}
+
 	// 关闭连接
 	if peer.Connection != nil {
 		peer.Connection.Close()
@@ -151,22 +197,83 @@ func (peer *AsyncClientPeer) Close() {
 		peer.reader = nil
 	}
 
-	if peer.writer != nil {
-		// 清空写入队列中的缓冲区
-		for {
-			ptr := peer.writer.writeQueue.Pop()
-			if ptr == nil {
-				break
-			}
-			req := (*writeRequest)(ptr)
-			if req.buffer != nil {
-				req.buffer.Release()
-			}
-		}
-		peer.writer = nil
+	atomic.StoreInt32(&peer.state, int32(PeerStateClosed))
+
+	// 从注册表注销
+	if peer.manager != nil {
+		peer.manager.remove(peer)
 	}
 
-	atomic.StoreInt32(&peer.state, int32(PeerStateClosed))
+	// 执行会话清理函数，可恢复会话在宽限期结束后才执行
+	if s := peer.Session(); s.detach(peer) {
+		s.runCloseHooks(&ClientPeer{AsyncClientPeer: peer})
+	}
+
+	d := peer.Disconnect()
+	base.Zap().Sugar().Infof("conn %d closed: %s", peer.connID, d)
+	proc := peer.getProcessor()
+	if proc != nil {
+		proc.countDisconnect(d.Reason)
+	}
+	// reactor上的连接没有读循环，由这里投递移除事件，可恢复会话挂起时为SuspendEvent
+	// WebSocket和KCP在读循环退出时投递
+	if atomic.LoadInt32(&peer.started) == 1 {
+		postPeerEvent(peer, peer.LeaveEvent())
+	}
+}
+
+// LeaveEvent 连接断开后应该投递给处理器的事件
+// 可恢复会话挂起时返回SuspendEvent，会话已经转移到其他连接时返回nil
+func (peer *AsyncClientPeer) LeaveEvent() *Event {
+	id := RemoveEvent
+	if rs := peer.Session().resumeState(); rs != nil {
+		id = rs.leaveEventID(peer)
+	}
+	if id == 0 {
+		return nil
+	}
+	return peer.leaveEvent(id)
+}
+
+// postPeerEvent 投递事件到处理这个peer的处理器，从不阻塞
+func postPeerEvent(peer *AsyncClientPeer, event *Event) {
+	if event == nil {
+		return
+	}
+	proc := peer.getProcessor()
+	if proc == nil {
+		return
+	}
+	// Close经常在处理器自己的goroutine中调用(回调、超时、踢人)，这时直接处理，不能等待自己的队列
+	target := proc.timerOwner(event.Peer)
+	if target.OnLoop() {
+		target.handleEvent(event)
+		return
+	}
+	select {
+	case target.EventChan <- event:
+		return
+	default:
+	}
+	// 队列满时进入溢出队列，不阻塞关闭连接的goroutine
+	if err := target.Post(func() { target.handleEvent(event) }); err != nil {
+		base.Zap().Sugar().Warnf("event %d for conn %d dropped: %v", event.ID, peer.connID, err)
+	}
+}
+
+// tryPostPeerEvent 事件队列有空间时投递，没有空间时返回false，不阻塞也不直接处理
+func tryPostPeerEvent(peer *AsyncClientPeer, event *Event) bool {
+	proc := peer.getProcessor()
+	if proc == nil {
+		return false
+	}
+	target := proc.timerOwner(event.Peer)
+	select {
+	case target.EventChan <- event:
+		return true
+	default:
+		return false
+	}
 }
 
 // Redirect 重新设置处理器（无锁实现）
@@ -186,8 +293,22 @@ func (peer *AsyncClientPeer) getProcessor() *Processor {
 	return peer.Proc
 }
 
+// Processor 当前处理消息的处理器，已经考虑了Redirect
+func (peer *AsyncClientPeer) Processor() *Processor {
+	return peer.getProcessor()
+}
+
 // SendMessage 发送消息（异步）
 func (peer *AsyncClientPeer) SendMessage(msg proto.Message, msgid int32) error {
+	if rs := peer.Session().resumeState(); rs != nil {
+		pm, err := NewPreparedMessage(msg, msgid)
+		if err != nil {
+			return err
+		}
+		defer pm.Release()
+		return rs.send(pm)
+	}
+
 	if peer.GetState() != PeerStateConnected {
 		return errors.New("connection is not connected")
 	}
@@ -238,6 +359,15 @@ func (peer *AsyncClientPeer) SendMessage(msg proto.Message, msgid int32) error {
 
 // SendMessageBuffer 发送缓冲区（异步）
 func (peer *AsyncClientPeer) SendMessageBuffer(data []byte) error {
+	if rs := peer.Session().resumeState(); rs != nil {
+		pm, err := newPreparedFrame(data)
+		if err != nil {
+			return err
+		}
+		defer pm.Release()
+		return rs.send(pm)
+	}
+
 	if peer.GetState() != PeerStateConnected {
 		return errors.New("connection is not connected")
 	}
@@ -272,9 +402,9 @@ func (peer *AsyncClientPeer) SendMessageBuffer(data []byte) error {
 		buffer: buffer,
 	}
 
-	if !peer.writer.writeQueue.Push(unsafe.Pointer(writeReq)) {
+	if err := peer.enqueueWrite(writeReq); err != nil {
 		buffer.Release()
-		return errors.New("write queue full")
+		return err
 	}
 
 	peer.writer.tryAsyncWrite()
@@ -284,6 +414,15 @@ func (peer *AsyncClientPeer) SendMessageBuffer(data []byte) error {
 
 // TransmitMsg 转发消息（异步）
 func (peer *AsyncClientPeer) TransmitMsg(msg *Message) error {
+	if rs := peer.Session().resumeState(); rs != nil {
+		pm, err := NewPreparedMessageFromBytes(msg.Head.ID, msg.Body)
+		if err != nil {
+			return err
+		}
+		defer pm.Release()
+		return rs.send(pm)
+	}
+
 	if peer.GetState() != PeerStateConnected {
 		return errors.New("connection is not connected")
 	}
@@ -328,9 +467,9 @@ func (peer *AsyncClientPeer) TransmitMsg(msg *Message) error {
 		buffer: buffer,
 	}
 
-	if !peer.writer.writeQueue.Push(unsafe.Pointer(writeReq)) {
+	if err := peer.enqueueWrite(writeReq); err != nil {
 		buffer.Release()
-		return errors.New("write queue full")
+		return err
 	}
 
 	peer.writer.tryAsyncWrite()
@@ -341,7 +480,11 @@ func (peer *AsyncClientPeer) TransmitMsg(msg *Message) error {
 // StartAsyncIO 开始异步I/O处理
 func (peer *AsyncClientPeer) StartAsyncIO() error {
 	// 将peer注册到reactor
-	return peer.reactor.AddFd(peer.fd, EpollIn|EpollOut|EpollET, peer)
+	if err := peer.reactor.AddFd(peer.fd, EpollIn|EpollOut|EpollET, peer); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&peer.started, 1)
+	return nil
 }
 
 // OnRead 实现AsyncIOHandler接口 - 处理读事件
@@ -356,7 +499,8 @@ func (peer *AsyncClientPeer) OnRead(fd int, data []byte) error {
 	// 将数据投递给消息读取器
 	messages, err := peer.reader.FeedData(data)
 	if err != nil {
-		base.Zap().Sugar().Warnf("message parse error: %v", err)
+		peer.ReportParseError(err)
+		peer.SetDisconnect(DisconnectParseError, err)
 		peer.Close()
 		return err
 	}
@@ -372,19 +516,7 @@ func (peer *AsyncClientPeer) OnRead(fd int, data []byte) error {
 		}
 
 		// 处理消息
-		if proc.ImmediateMode {
-			if cb, ok := proc.CallbackMap[msg.Head.ID]; ok {
-				cb(msg)
-			} else if proc.UnHandledHandler != nil {
-				proc.UnHandledHandler(msg)
-			}
-		} else {
-			select {
-			case proc.MessageChan <- msg:
-			default:
-				base.Zap().Sugar().Warnf("message queue full, dropping message")
-			}
-		}
+		proc.PostMessage(msg)
 
 		// 释放零拷贝消息
 		zcMsg.Release()
@@ -399,8 +531,10 @@ func (peer *AsyncClientPeer) OnRead(fd int, data []byte) error {
 
 // OnWrite 实现AsyncIOHandler接口 - 处理写事件
 func (peer *AsyncClientPeer) OnWrite(fd int) error {
-	// 写事件由writer内部处理
-	peer.writer.tryAsyncWrite()
+	// 可写通知，继续写入发送缓冲区满时留下的数据
+	if w := peer.writer; w != nil {
+		w.resume()
+	}
 	return nil
 }
 
@@ -410,22 +544,8 @@ func (peer *AsyncClientPeer) OnError(fd int, err error) {
 
 	// 安全关闭连接
 	if peer.GetState() == PeerStateConnected {
+		peer.SetDisconnect(DisconnectError, err)
 		peer.Close()
-
-		// 发送移除事件
-		event := &Event{
-			ID:   RemoveEvent,
-			Peer: &ClientPeer{AsyncClientPeer: peer},
-		}
-
-		proc := peer.getProcessor()
-		if proc != nil {
-			select {
-			case proc.EventChan <- event:
-			default:
-				base.Zap().Sugar().Errorf("event queue full when handling error, dropping remove event")
-			}
-		}
 	}
 }
 
@@ -440,22 +560,8 @@ func (peer *AsyncClientPeer) OnClose(fd int) {
 
 	// 安全关闭连接
 	if peer.GetState() != PeerStateClosed {
+		peer.SetDisconnect(DisconnectRemote, nil)
 		peer.Close()
-
-		// 发送移除事件
-		event := &Event{
-			ID:   RemoveEvent,
-			Peer: &ClientPeer{AsyncClientPeer: peer},
-		}
-
-		proc := peer.getProcessor()
-		if proc != nil {
-			select {
-			case proc.EventChan <- event:
-			default:
-				base.Zap().Sugar().Errorf("event queue full when handling close, dropping remove event")
-			}
-		}
 	}
 }
 
@@ -0,0 +1,174 @@
+package network
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+// PeerFilter 广播时过滤peer，返回true表示发送
+type PeerFilter func(peer *ClientPeer) bool
+
+// PeerManager 在线peer注册表
+// peer在创建时同步注册，在Close时同步注销，不依赖AddEvent/RemoveEvent，所以不会因为事件队列满而丢失
+type PeerManager struct {
+	mu     sync.RWMutex
+	byConn map[uint64]*AsyncClientPeer
+	byID   map[int64]*AsyncClientPeer
+	// 按身份索引已登录的会话，见AuthStage.Duplicate
+	byPrincipal map[int64][]*principalEntry
+}
+
+// NewPeerManager 创建peer注册表
+func NewPeerManager() *PeerManager {
+	return &PeerManager{
+		byConn: make(map[uint64]*AsyncClientPeer),
+		byID:   make(map[int64]*AsyncClientPeer),
+
+		byPrincipal: make(map[int64][]*principalEntry),
+	}
+}
+
+// add 注册peer
+func (m *PeerManager) add(peer *AsyncClientPeer) {
+	m.mu.Lock()
+	m.byConn[peer.connID] = peer
+	if id := atomic.LoadInt64(&peer.ID); id != 0 {
+		m.byID[id] = peer
+	}
+	m.mu.Unlock()
+	peer.manager = m
+}
+
+// remove 注销peer
+func (m *PeerManager) remove(peer *AsyncClientPeer) {
+	m.mu.Lock()
+	if cur, ok := m.byConn[peer.connID]; ok && cur == peer {
+		delete(m.byConn, peer.connID)
+	}
+	if id := atomic.LoadInt64(&peer.ID); m.byID[id] == peer {
+		delete(m.byID, id)
+	}
+	m.mu.Unlock()
+}
+
+// BindID 设置peer的ID并建立索引，之后可以通过GetByID查找
+// 同一个ID只会索引到最后绑定的peer
+func (m *PeerManager) BindID(peer *ClientPeer, id int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old := atomic.LoadInt64(&peer.ID); m.byID[old] == peer.AsyncClientPeer {
+		delete(m.byID, old)
+	}
+	atomic.StoreInt64(&peer.ID, id)
+	if id == 0 {
+		return
+	}
+	if _, ok := m.byConn[peer.connID]; ok {
+		m.byID[id] = peer.AsyncClientPeer
+	}
+}
+
+// GetByID 根据ID查找peer
+func (m *PeerManager) GetByID(id int64) *ClientPeer {
+	m.mu.RLock()
+	peer, ok := m.byID[id]
+	m.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return &ClientPeer{AsyncClientPeer: peer}
+}
+
+// GetByConnID 根据连接ID查找peer
+func (m *PeerManager) GetByConnID(connID uint64) *ClientPeer {
+	m.mu.RLock()
+	peer, ok := m.byConn[connID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return &ClientPeer{AsyncClientPeer: peer}
+}
+
+// Count 在线数量
+func (m *PeerManager) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.byConn)
+}
+
+// Snapshot 返回当前所有peer的快照，遍历期间注册表可以被修改
+func (m *PeerManager) Snapshot() []*ClientPeer {
+	m.mu.RLock()
+	peers := make([]*ClientPeer, 0, len(m.byConn))
+	for _, peer := range m.byConn {
+		peers = append(peers, &ClientPeer{AsyncClientPeer: peer})
+	}
+	m.mu.RUnlock()
+	return peers
+}
+
+// Range 遍历快照，f返回false时停止
+func (m *PeerManager) Range(f func(peer *ClientPeer) bool) {
+	for _, peer := range m.Snapshot() {
+		if !f(peer) {
+			return
+		}
+	}
+}
+
+// Broadcast 广播消息，消息只序列化一次，返回成功发送的数量
+func (m *PeerManager) Broadcast(msg proto.Message, msgid int32, filter PeerFilter) (int, error) {
+	pm, err := NewPreparedMessage(msg, msgid)
+	if err != nil {
+		return 0, err
+	}
+	defer pm.Release()
+	return m.BroadcastPrepared(pm, filter), nil
+}
+
+// BroadcastPrepared 广播预先编码的消息，所有peer共享同一个帧
+func (m *PeerManager) BroadcastPrepared(pm *PreparedMessage, filter PeerFilter) int {
+	sent := 0
+	for _, peer := range m.Snapshot() {
+		if filter != nil && !filter(peer) {
+			continue
+		}
+		if err := peer.SendPrepared(pm); err != nil {
+			base.Zap().Sugar().Debugf("broadcast to conn %d failed: %v", peer.connID, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// SendTo 发送消息给指定ID的peer，消息只序列化一次，返回成功发送的数量
+func (m *PeerManager) SendTo(ids []int64, msg proto.Message, msgid int32) (int, error) {
+	pm, err := NewPreparedMessage(msg, msgid)
+	if err != nil {
+		return 0, err
+	}
+	defer pm.Release()
+	return m.SendPreparedTo(ids, pm), nil
+}
+
+// SendPreparedTo 发送预先编码的消息给指定ID的peer
+func (m *PeerManager) SendPreparedTo(ids []int64, pm *PreparedMessage) int {
+	sent := 0
+	for _, id := range ids {
+		peer := m.GetByID(id)
+		if peer == nil {
+			continue
+		}
+		if err := peer.SendPrepared(pm); err != nil {
+			base.Zap().Sugar().Debugf("send to %d failed: %v", id, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
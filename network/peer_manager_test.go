@@ -0,0 +1,133 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// recordConn 记录写入内容的net.Conn
+type recordConn struct {
+	mu     sync.Mutex
+	writes [][]byte
+}
+
+func (c *recordConn) Read(b []byte) (int, error) { return 0, net.ErrClosed }
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.writes = append(c.writes, append([]byte(nil), b...))
+	c.mu.Unlock()
+	return len(b), nil
+}
+func (c *recordConn) Close() error                       { return nil }
+func (c *recordConn) LocalAddr() net.Addr                { return nil }
+func (c *recordConn) RemoteAddr() net.Addr               { return nil }
+func (c *recordConn) SetDeadline(t time.Time) error      { return nil }
+func (c *recordConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *recordConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (c *recordConn) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.writes)
+}
+
+func TestPeerManager(t *testing.T) {
+	proc := NewProcessor()
+	conns := make([]*recordConn, 3)
+	peers := make([]*ClientPeer, 3)
+	for i := range conns {
+		conns[i] = &recordConn{}
+		peers[i] = NewWebSocketClientPeer(conns[i], proc)
+		proc.Peers.BindID(peers[i], int64(i+1))
+	}
+	if proc.Peers.Count() != 3 {
+		t.Fatalf("count = %d", proc.Peers.Count())
+	}
+	if p := proc.Peers.GetByID(2); p == nil || p.AsyncClientPeer != peers[1].AsyncClientPeer {
+		t.Fatalf("lookup by id failed")
+	}
+	if p := proc.Peers.GetByConnID(peers[2].ConnID()); p == nil || p.ID != 3 {
+		t.Fatalf("lookup by conn id failed")
+	}
+
+	peers[0].Close()
+	if proc.Peers.Count() != 2 || proc.Peers.GetByID(1) != nil {
+		t.Fatalf("closed peer still registered")
+	}
+
+	pm, err := NewPreparedMessageFromBytes(10, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := proc.Peers.SendPreparedTo([]int64{1, 2}, pm); n != 1 {
+		t.Fatalf("send to = %d", n)
+	}
+	if n := proc.Peers.BroadcastPrepared(pm, func(p *ClientPeer) bool { return p.ID == 3 }); n != 1 {
+		t.Fatalf("broadcast = %d", n)
+	}
+	pm.Release()
+	if string(conns[2].writes[0][8:]) != "hello" {
+		t.Fatalf("unexpected frame %v", conns[2].writes[0])
+	}
+	if conns[1].count() != 1 || conns[2].count() != 1 || conns[0].count() != 0 {
+		t.Fatalf("unexpected writes %d %d %d", conns[0].count(), conns[1].count(), conns[2].count())
+	}
+}
+
+// testProto 测试用的消息
+type testProto struct {
+	Text string `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+}
+
+func (m *testProto) Reset()         { *m = testProto{} }
+func (m *testProto) String() string { return m.Text }
+func (*testProto) ProtoMessage()    {}
+
+func TestPeerManagerBroadcast(t *testing.T) {
+	proc := NewProcessor()
+	conns := make([]*recordConn, 3)
+	for i := range conns {
+		conns[i] = &recordConn{}
+		proc.Peers.BindID(NewWebSocketClientPeer(conns[i], proc), int64(i+1))
+	}
+	msg := &testProto{Text: "hello"}
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(c *recordConn, i int) {
+		t.Helper()
+		c.mu.Lock()
+		frame := c.writes[i]
+		c.mu.Unlock()
+		head, err := ReadHeadFromBuffer(frame)
+		if err != nil || head.ID != 20 || int(head.Length) != len(body) || string(frame[8:]) != string(body) {
+			t.Fatalf("unexpected frame %v", frame)
+		}
+	}
+
+	n, err := proc.Peers.Broadcast(msg, 20, func(p *ClientPeer) bool { return p.ID != 2 })
+	if err != nil || n != 2 {
+		t.Fatalf("broadcast = %d, %v", n, err)
+	}
+	if conns[0].count() != 1 || conns[1].count() != 0 || conns[2].count() != 1 {
+		t.Fatalf("unexpected writes %d %d %d", conns[0].count(), conns[1].count(), conns[2].count())
+	}
+	check(conns[0], 0)
+	check(conns[2], 0)
+
+	// 不存在的ID被跳过
+	n, err = proc.Peers.SendTo([]int64{2, 3, 99}, msg, 20)
+	if err != nil || n != 2 {
+		t.Fatalf("send to = %d, %v", n, err)
+	}
+	if conns[1].count() != 1 || conns[2].count() != 2 {
+		t.Fatalf("unexpected writes %d %d", conns[1].count(), conns[2].count())
+	}
+	check(conns[1], 0)
+	check(conns[2], 1)
+}
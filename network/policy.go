@@ -0,0 +1,173 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+// ErrPermissionDenied 没有权限执行消息处理函数
+var ErrPermissionDenied = errors.New("permission denied")
+
+/*
+Policy 消息处理函数的权限要求，在分发之前统一检查，ImmediateMode、队列和工作池都一样
+
+  - 有Roles或者Scopes时必须已经登录
+  - Roles：拥有其中任意一个角色
+  - Scopes：拥有全部scope
+  - Require：根据会话属性判断，例如VIP等级，返回false表示拒绝
+
+检查失败时消息不会到达处理函数，回复DenyReply设置的错误消息，并写审计日志
+*/
+type Policy struct {
+	Roles   []string
+	Scopes  []string
+	Require func(s *Session) bool
+}
+
+// RequireRoles 需要任意一个角色
+func RequireRoles(roles ...string) Policy {
+	return Policy{Roles: roles}
+}
+
+// RequireScopes 需要全部scope
+func RequireScopes(scopes ...string) Policy {
+	return Policy{Scopes: scopes}
+}
+
+// RequireFunc 根据会话属性判断
+func RequireFunc(require func(s *Session) bool) Policy {
+	return Policy{Require: require}
+}
+
+// check 检查peer是否满足要求
+func (pol *Policy) check(peer *ClientPeer) error {
+	if peer == nil || peer.AsyncClientPeer == nil {
+		// 服务器内部投递的消息
+		return nil
+	}
+	s := peer.Session()
+	if len(pol.Roles) > 0 || len(pol.Scopes) > 0 {
+		principal := s.Principal()
+		if principal == nil {
+			return fmt.Errorf("%w: not authenticated", ErrPermissionDenied)
+		}
+		if len(pol.Roles) > 0 && !containsAny(principal.Roles, pol.Roles) {
+			return fmt.Errorf("%w: requires role %v", ErrPermissionDenied, pol.Roles)
+		}
+		for _, scope := range pol.Scopes {
+			if !containsAny(principal.Scopes, []string{scope}) {
+				return fmt.Errorf("%w: requires scope %s", ErrPermissionDenied, scope)
+			}
+		}
+	}
+	if pol.Require != nil && !pol.Require(s) {
+		return fmt.Errorf("%w: requirement not met", ErrPermissionDenied)
+	}
+	return nil
+}
+
+func containsAny(have, want []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AuditEntry 权限拒绝的审计记录
+type AuditEntry struct {
+	Time        time.Time
+	ConnID      uint64
+	PrincipalID int64 // 未登录时为0
+	MsgID       int32
+	Err         error
+}
+
+// DenyReply 构建拒绝时回复的消息，ok为false时不回复
+type DenyReply func(msg *Message, err error) (id int32, body []byte, ok bool)
+
+// defaultAudit 默认写日志
+func defaultAudit(e *AuditEntry) {
+	base.Zap().Sugar().Warnf("audit: conn %d principal %d denied message %d: %v",
+		e.ConnID, e.PrincipalID, e.MsgID, e.Err)
+}
+
+// AddCallbackWithPolicy 注册回调，并设置权限要求
+func (p *Processor) AddCallbackWithPolicy(id int32, policy Policy, callback MsgCallback) {
+	if id == 0 {
+		return
+	}
+	p.UpdateHandlers(func(set *HandlerSet) {
+		set.Messages[id] = callback
+		set.Policies[id] = policy
+	})
+}
+
+// SetPolicy 设置消息的权限要求，nil表示不检查
+func (p *Processor) SetPolicy(id int32, policy *Policy) {
+	p.UpdateHandlers(func(set *HandlerSet) {
+		if policy == nil {
+			delete(set.Policies, id)
+		} else {
+			set.Policies[id] = *policy
+		}
+	})
+}
+
+// SetDenyReply 设置权限拒绝时回复的消息
+func (p *Processor) SetDenyReply(reply DenyReply) {
+	p.modifyHandlers(func(t *HandlerTable) error {
+		t.denyReply = reply
+		return nil
+	})
+}
+
+// SetAuditHook 设置审计回调，默认写日志，可能在任意goroutine中调用
+func (p *Processor) SetAuditHook(hook func(e *AuditEntry)) {
+	p.modifyHandlers(func(t *HandlerTable) error {
+		t.audit = hook
+		return nil
+	})
+}
+
+// authorize 检查消息的权限要求，拒绝时回复并审计
+func (t *HandlerTable) authorize(msg *Message) bool {
+	pol, ok := t.set.Policies[msg.Head.ID]
+	if !ok {
+		return true
+	}
+	err := pol.check(msg.Peer)
+	if err == nil {
+		return true
+	}
+	peer := msg.Peer
+	entry := &AuditEntry{
+		Time:   time.Now(),
+		ConnID: peer.connID,
+		MsgID:  msg.Head.ID,
+		Err:    err,
+	}
+	if principal := peer.Session().Principal(); principal != nil {
+		entry.PrincipalID = principal.ID
+	}
+	audit := t.audit
+	if audit == nil {
+		audit = defaultAudit
+	}
+	audit(entry)
+
+	if t.denyReply != nil {
+		if id, body, ok := t.denyReply(msg, err); ok {
+			if e := sendFrame(peer, id, body); e != nil {
+				base.Zap().Sugar().Warnf("send deny reply to conn %d: %v", peer.connID, e)
+			}
+		}
+	}
+	return false
+}
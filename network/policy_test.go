@@ -0,0 +1,61 @@
+package network
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	proc := NewProcessor()
+	proc.ImmediateMode = true
+	var handled []int32
+	handle := func(msg *Message) { handled = append(handled, msg.Head.ID) }
+	proc.AddCallbackWithPolicy(1, RequireRoles("gm", "admin"), handle)
+	proc.AddCallbackWithPolicy(2, RequireScopes("chat", "trade"), handle)
+	proc.AddCallbackWithPolicy(3, RequireFunc(func(s *Session) bool {
+		vip, _ := Get[int](s, "vip")
+		return vip >= 3
+	}), handle)
+	proc.AddCallback(4, handle)
+
+	var audits []*AuditEntry
+	proc.SetAuditHook(func(e *AuditEntry) { audits = append(audits, e) })
+	proc.SetDenyReply(func(msg *Message, err error) (int32, []byte, bool) {
+		return 999, []byte(err.Error()), true
+	})
+
+	conn := &recordConn{}
+	peer := NewWebSocketClientPeer(conn, proc)
+	post := func(ids ...int32) {
+		for _, id := range ids {
+			proc.PostMessage(&Message{Peer: peer, Head: MessageHead{ID: id}})
+		}
+	}
+
+	post(1, 2, 3, 4)
+	peer.Session().Login(&Principal{ID: 7, Roles: []string{"admin"}, Scopes: []string{"chat"}})
+	Set(peer.Session(), "vip", 3)
+	post(1, 2, 3)
+	// 内部投递的消息不检查
+	proc.PostMessage(&Message{Head: MessageHead{ID: 2}})
+
+	want := []int32{4, 1, 3, 2}
+	if len(handled) != len(want) {
+		t.Fatalf("unexpected handled %v", handled)
+	}
+	for i := range want {
+		if handled[i] != want[i] {
+			t.Fatalf("unexpected handled %v", handled)
+		}
+	}
+	if len(audits) != 4 || audits[3].MsgID != 2 || audits[3].PrincipalID != 7 ||
+		!errors.Is(audits[3].Err, ErrPermissionDenied) {
+		t.Fatalf("unexpected audits %+v", audits)
+	}
+	if len(conn.writes) != 4 {
+		t.Fatalf("expected 4 deny replies, got %d", len(conn.writes))
+	}
+	if pol, ok := proc.Handlers().Policy(1); !ok || len(pol.Roles) != 2 {
+		t.Fatal("policy not in handler table")
+	}
+}
@@ -0,0 +1,125 @@
+package network
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// PreparedMessage 预先编码好的消息帧，用于一次编码多次发送
+// TCP、KCP、WebSocket使用相同的帧格式(8字节头+消息体)，所以帧只需要构建一次
+// 帧保存在引用计数的Buffer中，每个写入队列持有一个引用，最后一个写入完成时归还到池
+type PreparedMessage struct {
+	buffer *Buffer
+}
+
+// NewPreparedMessage 序列化proto消息并构建帧
+func NewPreparedMessage(msg proto.Message, msgID int32) (*PreparedMessage, error) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return NewPreparedMessageFromBytes(msgID, data)
+}
+
+// NewPreparedMessageFromBytes 使用已经序列化的消息体构建帧
+func NewPreparedMessageFromBytes(msgID int32, body []byte) (*PreparedMessage, error) {
+	buffer := GetBuffer()
+	totalLen := len(body) + 8
+	if err := buffer.EnsureSpace(totalLen); err != nil {
+		buffer.Release()
+		return nil, err
+	}
+
+	head := MessageHead{
+		Length: int32(len(body)),
+		ID:     msgID,
+	}
+	if err := WriteHeadToBuffer(buffer, head); err != nil {
+		buffer.Release()
+		return nil, err
+	}
+	if err := buffer.SafeCopy(body); err != nil {
+		buffer.Release()
+		return nil, err
+	}
+	return &PreparedMessage{buffer: buffer}, nil
+}
+
+// newPreparedFrame 拷贝一个已经带消息头的完整帧
+func newPreparedFrame(frame []byte) (*PreparedMessage, error) {
+	buffer := GetBuffer()
+	if err := buffer.SafeCopy(frame); err != nil {
+		buffer.Release()
+		return nil, err
+	}
+	return &PreparedMessage{buffer: buffer}, nil
+}
+
+// Bytes 完整的帧数据，只读
+func (pm *PreparedMessage) Bytes() []byte {
+	return pm.buffer.Bytes()
+}
+
+// Len 帧长度
+func (pm *PreparedMessage) Len() int {
+	return pm.buffer.Len()
+}
+
+// Release 释放创建者持有的引用，已经入队的写入不受影响
+func (pm *PreparedMessage) Release() {
+	if pm.buffer != nil {
+		pm.buffer.Release()
+		pm.buffer = nil
+	}
+}
+
+// SendPrepared 发送预先编码的消息（异步），不会拷贝帧数据
+func (peer *AsyncClientPeer) SendPrepared(pm *PreparedMessage) error {
+	if rs := peer.Session().resumeState(); rs != nil {
+		return rs.send(pm)
+	}
+	return peer.sendPreparedDirect(pm)
+}
+
+// sendFrameDirect 直接发送一个完整帧，不经过会话恢复
+func (peer *AsyncClientPeer) sendFrameDirect(frame []byte) error {
+	pm, err := newPreparedFrame(frame)
+	if err != nil {
+		return err
+	}
+	defer pm.Release()
+	return peer.sendPreparedDirect(pm)
+}
+
+// sendPreparedDirect 直接写入连接，不经过会话恢复
+func (peer *AsyncClientPeer) sendPreparedDirect(pm *PreparedMessage) error {
+	if peer.GetState() != PeerStateConnected {
+		return errors.New("connection is not connected")
+	}
+	if pm.buffer == nil {
+		return ErrInvalidBufferOp
+	}
+
+	// WebSocket和KCP直接同步写入
+	if peer.fd == -1 {
+		_, err := peer.Connection.Write(pm.Bytes())
+		atomic.AddUint64(&peer.bytesWritten, uint64(pm.Len()))
+		return err
+	}
+
+	// 每个写入请求持有一个引用，在doWrite中释放
+	pm.buffer.AddRef()
+	writeReq := &writeRequest{
+		fd:     peer.fd,
+		buffer: pm.buffer,
+	}
+	if err := peer.enqueueWrite(writeReq); err != nil {
+		pm.buffer.Release()
+		return err
+	}
+
+	peer.writer.tryAsyncWrite()
+	return nil
+}
@@ -1,8 +1,12 @@
 package network
 
 import (
+	"fmt"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"github.com/liangpengcheng/qcontinuum/base"
 )
@@ -28,56 +32,135 @@ type Event struct {
 	ID    int32       //eventid
 	Param string      //param
 	Peer  *ClientPeer //事件中的peer,可以为nil
+	// Payload 任意类型的数据，用Payload[T]取出，内置事件的类型见events.go
+	Payload interface{}
 }
 
 var (
 	// ExitEvent 退出
 	ExitEvent int32 = 1
-	// AddEvent 加入玩家
+	// AddEvent 加入玩家，Payload为*Connected
 	AddEvent int32 = 2
-	// RemoveEvent 删除玩家
+	// RemoveEvent 删除玩家，Payload为*Disconnected
 	RemoveEvent int32 = 3
+	// SuspendEvent 可恢复会话断线，等待重连，Payload为*Disconnected
+	SuspendEvent int32 = 4
+	// ResumeEvent 可恢复会话重连成功，Peer为新的连接
+	ResumeEvent int32 = 5
 )
 
 // Processor 消息处理器
 type Processor struct {
 	MessageChan chan *Message
 	//SendChan       chan *Message
-	EventChan        chan *Event
-	FuncChan         chan ProcFunction
-	CallbackMap      map[int32]MsgCallback
-	UnHandledHandler MsgCallback //未注册的消息处理
-	EventCallback    map[int32]EventCallback
-	updateCallback   ProcFunction
+	EventChan chan *Event
+	FuncChan  chan ProcFunction
+	// CallbackMap 兼容直接写入回调的旧代码，只能在StartProcess之前写入，
+	// 启动时复制到处理函数表(表中已有的消息不覆盖)并清空，之后写入的不再生效
+	//
+	// Deprecated: 使用AddCallback、UpdateHandlers注册，Handlers读取
+	CallbackMap map[int32]MsgCallback
+	// UnHandledHandler 未注册的消息处理，优先使用SetUnhandledHandler
+	UnHandledHandler MsgCallback
+	// EventCallback 兼容直接写入回调的旧代码，规则和CallbackMap一样
+	//
+	// Deprecated: 使用AddEventCallback、UpdateHandlers注册，Handlers读取
+	EventCallback map[int32]EventCallback
+	// Peers 由这个处理器创建的在线peer
+	Peers *PeerManager
+	// 优先级通道，MessageChan是LaneNormal，EventChan和FuncChan是LaneControl
+	highChan    chan *Message
+	bulkChan    chan *Message
+	laneWeights [laneCount]int
+	// 队列满时的处理方式和丢弃统计
+	overload [queueCount]OverloadPolicy
+	drops    *dropStats
+	// 按原因的断开统计
+	closes *closeStats
+	// Post的溢出队列
+	overflow *overflowQueue
+	// DispatchWorker消息的工作池和Go的任务池
+	workers    *workerPool
+	jobs       *workerPool
+	queuedJobs *jobSet
+	// 处理循环所在的goroutine
+	loopGID uint64
+
+	updateCallback ProcFunction
 	// 更新时间
 	loopTime time.Duration
 	// ImmediateMode 立即回调消息，如果想要线程安全，必须设置为false，默认为false
 	ImmediateMode bool
+	// PanicHook 处理函数panic时的报告回调，默认写日志
+	PanicHook func(report *PanicReport)
+	// PanicPolicy 消息处理函数panic后对peer的处理，默认不处理
+	PanicPolicy PanicPolicy
+	breaker     *panicBreaker
+	// 定时器，回调在处理循环中执行
+	timers *timerQueue
+	wheel  *base.TimingWheel
+
+	// 处理函数表，写时复制，原子替换，见handlers.go
+	handlers   unsafe.Pointer
+	handlersMu sync.Mutex
+
+	// 分片处理器组，group是入口处理器所属的组，parent是分片对应的入口处理器
+	group  *ProcessorGroup
+	parent *Processor
+
+	// 运行状态，quit在开始停止时关闭，done在处理循环退出时关闭
+	lifeMu sync.Mutex
+	state  int32
+	abort  int32
+	quit   chan struct{}
+	done   chan struct{}
 }
 
 // NewProcessor 新建处理器，包含初始化操作
-func NewProcessor() *Processor {
-	return NewProcessorWithLoopTime(24 * time.Hour)
+// 默认消息通道1024，事件1024，函数64，可以通过选项修改容量和队列满时的处理方式
+func NewProcessor(opts ...ProcessorOption) *Processor {
+	return NewProcessorWithLoopTime(24*time.Hour, opts...)
 }
 
 // NewProcessorWithLoopTime 指定定时器
-func NewProcessorWithLoopTime(time time.Duration) *Processor {
+func NewProcessorWithLoopTime(time time.Duration, opts ...ProcessorOption) *Processor {
 	p := &Processor{
 		MessageChan: make(chan *Message, 1024),
 		//SendChan:      make(chan *Message, 1024),
 		EventChan:     make(chan *Event, 1024),
 		FuncChan:      make(chan ProcFunction, 64),
+		highChan:      make(chan *Message, 1024),
+		bulkChan:      make(chan *Message, 1024),
+		laneWeights:   defaultLaneWeights,
+		overload:      defaultOverload,
+		drops:         newDropStats(),
+		closes:        newCloseStats(),
+		overflow:      newOverflowQueue(),
+		workers:       newWorkerPool(0, defaultWorkerCapacity),
+		jobs:          newWorkerPool(defaultJobWorkers, defaultJobCapacity),
+		queuedJobs:    newJobSet(),
 		EventCallback: make(map[int32]EventCallback),
 		CallbackMap:   make(map[int32]MsgCallback),
+		breaker:       newPanicBreaker(),
+		timers:        newTimerQueue(),
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+		Peers:         NewPeerManager(),
 		loopTime:      time,
 		ImmediateMode: false,
 	}
+	p.handlers = unsafe.Pointer(newHandlerTable())
+	for _, opt := range opts {
+		opt(p)
+	}
 	return p
 }
 
 // AddCallback 设置回调
 func (p *Processor) addCallback(id int32, callback MsgCallback) {
-	p.CallbackMap[id] = callback
+	p.UpdateHandlers(func(set *HandlerSet) {
+		set.Messages[id] = callback
+	})
 }
 
 // AddCallback 设置回调
@@ -95,17 +178,23 @@ func (p *Processor) SetUpdate(uptime time.Duration, upcall ProcFunction) {
 
 // RemoveCallback 删除回调
 func (p *Processor) RemoveCallback(id int32) {
-	delete(p.CallbackMap, id)
+	p.UpdateHandlers(func(set *HandlerSet) {
+		delete(set.Messages, id)
+	})
 }
 
 // AddEventCallback 事件处理函数注册
 func (p *Processor) AddEventCallback(id int32, callback EventCallback) {
-	p.EventCallback[id] = callback
+	p.UpdateHandlers(func(set *HandlerSet) {
+		set.Events[id] = callback
+	})
 }
 
 // RemoveEventCallback 删除事件处理回调
 func (p *Processor) RemoveEventCallback(id int32) {
-	delete(p.EventCallback, id)
+	p.UpdateHandlers(func(set *HandlerSet) {
+		delete(set.Events, id)
+	})
 }
 
 /*
@@ -120,44 +209,257 @@ func (p *Processor) send() {
 	}
 }
 */
+// handlerOwner 回调注册所在的处理器，分片使用入口处理器的回调
+func (p *Processor) handlerOwner() *Processor {
+	if p.parent != nil {
+		return p.parent
+	}
+	return p
+}
+
+// HandleMessage 在当前goroutine中经过中间件链调用消息回调
+// 回调panic会被recover并报告，不会影响调用者
+func (p *Processor) HandleMessage(msg *Message) {
+	h := p.handlerOwner()
+	if msg.Peer != nil && msg.Peer.AsyncClientPeer != nil && msg.Peer.Session().Quarantined() {
+		return
+	}
+	if h.breaker.isOpen(msg.Head.ID, time.Now()) {
+		base.Zap().Sugar().Warnf("callback(%d) disabled by panic breaker", msg.Head.ID)
+		return
+	}
+	defer p.recoverMessage(msg)
+
+	// 整个分发过程使用同一个版本的处理函数表
+	t := h.table()
+	if !t.authorize(msg) {
+		return
+	}
+	if cb, ok := t.chains[msg.Head.ID]; ok {
+		cb(msg)
+	} else if t.set.Unhandled != nil {
+		t.buildChain(msg.Head.ID, t.set.Unhandled)(msg)
+	} else if h.UnHandledHandler != nil {
+		// 直接设置UnHandledHandler
+		t.buildChain(msg.Head.ID, h.UnHandledHandler)(msg)
+	} else {
+		base.Zap().Sugar().Warnf("can't find callback(%d)", msg.Head.ID)
+	}
+}
+
+// handleEvent 在当前goroutine中调用事件回调
+// 每个回调单独recover，一个panic不影响其他订阅
+func (p *Processor) handleEvent(event *Event) {
+	t := p.table()
+	if event.ID == AddEvent {
+		p.startLogin(t.auth, event.Peer)
+	}
+	if cb, ok := t.set.Events[event.ID]; ok {
+		p.callEvent(cb, event)
+	}
+	for _, sub := range t.subs[event.ID] {
+		p.callEvent(sub.fn, event)
+	}
+}
+
+// Owner 实际处理这个peer的处理器，处理器组的入口返回对应的分片
+func (p *Processor) Owner(peer *ClientPeer) *Processor {
+	if p.group == nil || peer == nil {
+		return p
+	}
+	return p.group.ShardForPeer(peer)
+}
+
+// routeMessage 消息应该投递到的处理器
+func (p *Processor) routeMessage(msg *Message) *Processor {
+	if p.group == nil {
+		return p
+	}
+	return p.group.shardForMessage(msg)
+}
+
+// PostMessage 投递消息，开启登录阶段时先检查登录，然后按消息ID的DispatchMode分发，ImmediateMode时直接回调，队列满时按QueueMessage的策略处理
+// 消息被丢弃或者处理器已停止时返回false
+func (p *Processor) PostMessage(msg *Message) bool {
+	if pass, accepted := p.admit(msg); !pass {
+		return accepted
+	}
+	if handled, ok := p.dispatch(msg, p.routeMessage(msg).overload[QueueMessage]); handled {
+		return ok
+	}
+	if p.ImmediateMode {
+		p.HandleMessage(msg)
+		return true
+	}
+	target := p.routeMessage(msg)
+	if !target.accepting() {
+		return false
+	}
+	return p.enqueueMessage(target, msg, target.overload[QueueMessage])
+}
+
+// PostMessageWait 投递消息，ImmediateMode时直接回调，队列满时阻塞等待
+// 处理器停止时返回ErrProcessorStopped
+func (p *Processor) PostMessageWait(msg *Message) error {
+	if pass, accepted := p.admit(msg); !pass {
+		if !accepted {
+			return ErrAuthRequired
+		}
+		return nil
+	}
+	if handled, ok := p.dispatch(msg, OverloadBlock); handled {
+		if !ok {
+			return ErrProcessorStopped
+		}
+		return nil
+	}
+	if p.ImmediateMode {
+		p.HandleMessage(msg)
+		return nil
+	}
+	target := p.routeMessage(msg)
+	if !target.accepting() || !p.enqueueMessage(target, msg, OverloadBlock) {
+		return ErrProcessorStopped
+	}
+	return nil
+}
+
+// routeEvent 事件应该投递到的处理器，ExitEvent投递给所有分片，没有peer的事件投递给第一个分片
+func (p *Processor) routeEvent(event *Event) []*Processor {
+	if p.group == nil {
+		return []*Processor{p}
+	}
+	if event.ID == ExitEvent {
+		return p.group.shards
+	}
+	return []*Processor{p.Owner(event.Peer)}
+}
+
+// PostEvent 投递事件，队列满时按QueueEvent的策略处理
+// 事件被丢弃或者处理器已停止时返回false
+func (p *Processor) PostEvent(event *Event) bool {
+	ok := true
+	for _, target := range p.routeEvent(event) {
+		if !target.accepting() || !p.enqueueEvent(target, event, target.overload[QueueEvent]) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// PostEventWait 投递事件，队列满时阻塞等待，处理器停止时返回ErrProcessorStopped
+func (p *Processor) PostEventWait(event *Event) error {
+	var err error
+	for _, target := range p.routeEvent(event) {
+		if !target.accepting() || !p.enqueueEvent(target, event, OverloadBlock) {
+			err = ErrProcessorStopped
+		}
+	}
+	return err
+}
+
 // StartProcess 开始处理信息
 // 只有调用了这个借口，处理器才会处理实际的信息，以及实际发送消息
+// 阻塞到ExitEvent或者Stop，停止之后可以再次调用
 func (p *Processor) StartProcess() {
+	if p.group != nil {
+		// 处理器组的入口运行所有分片
+		p.group.StartProcess()
+		return
+	}
+	p.run()
+}
+
+// run 开始运行周期并执行处理循环
+func (p *Processor) run() error {
+	if !p.begin() {
+		base.Zap().Sugar().Errorf("processor is already running")
+		return errProcessorRunning
+	}
+	return p.loop()
+}
+
+// loop 处理循环，必须先调用begin，处理循环本身panic时返回错误
+func (p *Processor) loop() (err error) {
+	quit := p.quitChan()
+	defer p.end()
+	atomic.StoreUint64(&p.loopGID, goroutineID())
+	defer atomic.StoreUint64(&p.loopGID, 0)
 	defer func() {
-		if err := recover(); err != nil {
-			base.Zap().Sugar().Errorf("%v", err)
+		if r := recover(); r != nil {
+			base.Zap().Sugar().Errorf("%v", r)
 			base.Zap().Sugar().Errorf("stacks%s", debug.Stack())
-
+			err = fmt.Errorf("processor panic: %v", r)
 		}
 	}()
 	//go p.send()
 	base.Zap().Sugar().Infof("processor is starting ")
-	tick := time.Tick(p.loopTime)
+	// 可以重新启动，不使用time.Tick避免泄漏
+	ticker := time.NewTicker(p.loopTime)
+	defer ticker.Stop()
+	tick := ticker.C
+	timerC := p.timers.channel()
+	// 时间轮只在有定时器时计时，空闲的处理器不会被唤醒
+	var wheelTicker *time.Ticker
+	var wheelC <-chan time.Time
+	var wheelWake <-chan struct{}
+	if p.wheel != nil {
+		wheelWake = p.wheel.Wake()
+		defer func() {
+			if wheelTicker != nil {
+				wheelTicker.Stop()
+			}
+		}()
+		if p.wheel.Len() > 0 {
+			wheelTicker = time.NewTicker(p.wheel.Tick())
+			wheelC = wheelTicker.C
+		}
+	}
 	for {
 		select {
 		case msg := <-p.MessageChan:
-			if cb, ok := p.CallbackMap[msg.Head.ID]; ok {
-				cb(msg)
-			} else if p.UnHandledHandler != nil {
-				p.UnHandledHandler(msg)
-			} else {
-				base.Zap().Sugar().Warnf("can't find callback(%d)", msg.Head.ID)
-			}
+			p.HandleMessage(msg)
+		case msg := <-p.highChan:
+			p.HandleMessage(msg)
+		case msg := <-p.bulkChan:
+			p.HandleMessage(msg)
 		case event := <-p.EventChan:
-			if event.ID == ExitEvent {
-				base.Zap().Sugar().Infof("Processor exit : %s", event.Param)
+			if p.dispatchEvent(event) {
 				return
 			}
-			if cb, ok := p.EventCallback[event.ID]; ok {
-				cb(event)
-			}
 		case f := <-p.FuncChan:
-			f()
+			p.runFunc("func", f)
+		case <-p.overflow.wake:
+			p.runOverflow()
+		case <-quit:
+			p.drain()
+			base.Zap().Sugar().Infof("Processor stopped")
+			return
+		case <-p.timers.wake:
+			p.timers.reset()
+		case <-timerC:
+			p.fireTimers()
+		case <-wheelWake:
+			if wheelTicker == nil {
+				wheelTicker = time.NewTicker(p.wheel.Tick())
+				wheelC = wheelTicker.C
+			}
+		case now := <-wheelC:
+			for _, f := range p.wheel.Poll(now) {
+				p.runFunc("timer", f)
+			}
+			if p.wheel.Len() == 0 {
+				wheelTicker.Stop()
+				wheelTicker, wheelC = nil, nil
+			}
 		case <-tick:
 			if p.updateCallback != nil {
-				p.updateCallback()
+				p.runFunc("update", p.updateCallback)
 			}
 		}
-
+		// 被唤醒之后按优先级和权重处理积压的内容
+		if p.serveLanes() {
+			return
+		}
 	}
 }
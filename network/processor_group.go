@@ -0,0 +1,200 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+/*
+ProcessorGroup 分片处理器组
+
+入口处理器(Processor())和普通处理器用法一样：设置给服务器、注册回调、Redirect都可以，
+消息和事件会按key一致性哈希到N个分片，每个分片有自己的处理循环
+
+  - 默认按peer的会话分片，同一个peer的消息和事件总是由同一个分片按顺序处理，
+    会话恢复到新连接后仍然由原来的分片处理
+  - 设置KeyFunc后按消息内容分片，同一个key的消息有序，同一个peer不同key的消息之间不保证顺序
+  - ExitEvent投递给所有分片，没有peer的自定义事件投递给第一个分片
+  - 回调注册在入口处理器上，所有分片共用，同一个回调会在多个分片的goroutine中并发执行
+  - 入口的MessageChan、EventChan、FuncChan就是第一个分片的通道，直接写入这些通道的由第一个分片处理
+*/
+type ProcessorGroup struct {
+	entry  *Processor
+	shards []*Processor
+	// KeyFunc 从消息中提取分片key，返回false时按peer分片，必须在StartProcess之前设置
+	KeyFunc func(msg *Message) (uint64, bool)
+
+	lifeMu  sync.Mutex
+	running bool
+	done    chan struct{} // 当前运行周期的Done，所有分片退出后关闭
+}
+
+// NewProcessorGroup 创建n个分片的处理器组，选项作用于入口和每个分片
+func NewProcessorGroup(n int, opts ...ProcessorOption) *ProcessorGroup {
+	return NewProcessorGroupWithLoopTime(n, 24*time.Hour, opts...)
+}
+
+// NewProcessorGroupWithLoopTime 创建处理器组，并指定分片的定时器
+func NewProcessorGroupWithLoopTime(n int, loopTime time.Duration, opts ...ProcessorOption) *ProcessorGroup {
+	if n <= 0 {
+		n = 1
+	}
+	g := &ProcessorGroup{
+		entry:  NewProcessorWithLoopTime(loopTime, opts...),
+		shards: make([]*Processor, n),
+		done:   make(chan struct{}),
+	}
+	g.entry.group = g
+	for i := range g.shards {
+		shard := NewProcessorWithLoopTime(loopTime, opts...)
+		shard.parent = g.entry
+		g.shards[i] = shard
+	}
+	g.aliasEntry()
+	return g
+}
+
+// aliasEntry 入口使用第一个分片的通道，兼容直接读写通道的旧代码
+func (g *ProcessorGroup) aliasEntry() {
+	first := g.shards[0]
+	g.entry.MessageChan = first.MessageChan
+	g.entry.EventChan = first.EventChan
+	g.entry.FuncChan = first.FuncChan
+	g.entry.highChan = first.highChan
+	g.entry.bulkChan = first.bulkChan
+}
+
+// Processor 入口处理器，交给服务器使用，并在上面注册回调
+func (g *ProcessorGroup) Processor() *Processor {
+	return g.entry
+}
+
+// Shards 所有分片
+func (g *ProcessorGroup) Shards() []*Processor {
+	return g.shards
+}
+
+// Len 分片数量
+func (g *ProcessorGroup) Len() int {
+	return len(g.shards)
+}
+
+// ShardFor 按key选择分片
+func (g *ProcessorGroup) ShardFor(key uint64) *Processor {
+	return g.shards[jumpHash(key, len(g.shards))]
+}
+
+// ShardForPeer 按peer的会话选择分片
+func (g *ProcessorGroup) ShardForPeer(peer *ClientPeer) *Processor {
+	if s := peer.Session(); s != nil {
+		return g.ShardFor(s.id)
+	}
+	return g.ShardFor(peer.connID)
+}
+
+// shardForMessage 按消息选择分片
+func (g *ProcessorGroup) shardForMessage(msg *Message) *Processor {
+	if g.KeyFunc != nil {
+		if key, ok := g.KeyFunc(msg); ok {
+			return g.ShardFor(key)
+		}
+	}
+	return g.ShardForPeer(msg.Peer)
+}
+
+// Post 把函数投递到key对应的分片上执行
+func (g *ProcessorGroup) Post(key uint64, f ProcFunction) error {
+	return g.ShardFor(key).postFunc(f)
+}
+
+// PostPeer 把函数投递到处理这个peer的分片上执行
+func (g *ProcessorGroup) PostPeer(peer *ClientPeer, f ProcFunction) error {
+	return g.ShardForPeer(peer).postFunc(f)
+}
+
+// StartProcess 启动所有分片，阻塞直到所有分片退出
+// 任何一个分片退出(ExitEvent、Stop或者处理循环panic)时，其他分片处理完队列后也退出
+func (g *ProcessorGroup) StartProcess() {
+	if err := g.begin(); err != nil {
+		base.Zap().Sugar().Errorf("processor group: %v", err)
+		return
+	}
+	g.loop()
+}
+
+// begin 开始所有分片的运行周期，有分片已经在运行时全部回滚
+func (g *ProcessorGroup) begin() error {
+	g.lifeMu.Lock()
+	defer g.lifeMu.Unlock()
+	for i, shard := range g.shards {
+		if !shard.begin() {
+			for _, started := range g.shards[:i] {
+				started.end()
+			}
+			return errProcessorRunning
+		}
+	}
+	select {
+	case <-g.done:
+		g.done = make(chan struct{})
+	default:
+	}
+	g.running = true
+	return nil
+}
+
+// finish 结束运行周期，关闭Done
+func (g *ProcessorGroup) finish() {
+	g.lifeMu.Lock()
+	defer g.lifeMu.Unlock()
+	g.running = false
+	select {
+	case <-g.done:
+	default:
+		close(g.done)
+	}
+}
+
+// loop 运行所有分片的处理循环，必须先调用begin
+// 第一个分片退出后停止其他分片，等待全部退出，返回第一个错误
+func (g *ProcessorGroup) loop() error {
+	errs := make(chan error, len(g.shards))
+	for _, shard := range g.shards {
+		go func(shard *Processor) {
+			errs <- shard.loop()
+		}(shard)
+	}
+	err := <-errs
+	for _, shard := range g.shards {
+		shard.beginStop(StopDrain)
+	}
+	for i := 1; i < len(g.shards); i++ {
+		if e := <-errs; err == nil {
+			err = e
+		}
+	}
+	g.entry.stopPools()
+	g.finish()
+	return err
+}
+
+// Exit 通知所有分片退出
+func (g *ProcessorGroup) Exit(param string) {
+	g.entry.PostEventWait(&Event{
+		ID:    ExitEvent,
+		Param: param,
+	})
+}
+
+// jumpHash 一致性哈希，分片数量变化时只有少量key需要迁移
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,198 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrProcessorStopped 处理器正在停止或者已经停止，不再接受投递
+var ErrProcessorStopped = errors.New("processor stopped")
+
+var errProcessorRunning = errors.New("processor is already running")
+
+// StopMode 停止方式
+type StopMode int
+
+const (
+	// StopDrain 处理完队列中已有的消息、事件和函数之后退出
+	StopDrain StopMode = iota
+	// StopNow 处理完当前的回调后立即退出，队列中剩余的内容保留到重新启动
+	StopNow
+)
+
+// 处理器状态
+const (
+	procIdle int32 = iota
+	procRunning
+	procStopping
+	procStopped
+)
+
+// accepting 是否接受新的投递，没有启动的处理器也接受，启动后处理
+func (p *Processor) accepting() bool {
+	return atomic.LoadInt32(&p.state) < procStopping
+}
+
+// quitChan 当前运行周期的停止信号
+func (p *Processor) quitChan() <-chan struct{} {
+	p.lifeMu.Lock()
+	defer p.lifeMu.Unlock()
+	return p.quit
+}
+
+// Done 处理循环退出时关闭，重新启动后返回新的channel
+func (p *Processor) Done() <-chan struct{} {
+	if p.group != nil {
+		return p.group.Done()
+	}
+	p.lifeMu.Lock()
+	defer p.lifeMu.Unlock()
+	return p.done
+}
+
+// Stopped 处理器是否正在停止或者已经停止
+func (p *Processor) Stopped() bool {
+	return !p.accepting()
+}
+
+// begin 开始一个运行周期，返回false表示已经在运行
+func (p *Processor) begin() bool {
+	p.lifeMu.Lock()
+	defer p.lifeMu.Unlock()
+	switch atomic.LoadInt32(&p.state) {
+	case procRunning, procStopping:
+		return false
+	case procStopped:
+		p.quit = make(chan struct{})
+		p.done = make(chan struct{})
+	}
+	// 旧代码直接写入的回调在启动时进入处理函数表
+	p.adoptLegacy()
+	atomic.StoreInt32(&p.abort, 0)
+	atomic.StoreInt32(&p.state, procRunning)
+	return true
+}
+
+// end 结束运行周期，唤醒等待投递和等待停止的goroutine
+func (p *Processor) end() {
+	if p.parent == nil {
+		// 分片共用入口的工作池，由处理器组在所有分片退出后停止
+		p.stopPools()
+	}
+	p.lifeMu.Lock()
+	if atomic.LoadInt32(&p.state) == procRunning {
+		// ExitEvent或者循环panic退出
+		close(p.quit)
+	}
+	atomic.StoreInt32(&p.state, procStopped)
+	close(p.done)
+	p.lifeMu.Unlock()
+}
+
+// stopPools 结束工作池和任务池的goroutine，下次提交时重新启动
+// 还没开始执行的任务以ErrProcessorStopped结束，不留到下次运行
+func (p *Processor) stopPools() {
+	p.workers.stop()
+	p.jobs.stop()
+	p.queuedJobs.fail(ErrProcessorStopped)
+}
+
+// beginStop 通知处理循环停止，返回运行周期的Done
+func (p *Processor) beginStop(mode StopMode) <-chan struct{} {
+	p.lifeMu.Lock()
+	defer p.lifeMu.Unlock()
+	if mode == StopNow {
+		atomic.StoreInt32(&p.abort, 1)
+	}
+	switch atomic.LoadInt32(&p.state) {
+	case procIdle:
+		// 没有启动过，直接进入停止状态，可以再启动
+		close(p.quit)
+		close(p.done)
+		atomic.StoreInt32(&p.state, procStopped)
+	case procRunning:
+		atomic.StoreInt32(&p.state, procStopping)
+		close(p.quit)
+	}
+	return p.done
+}
+
+// Stop 停止处理器，之后的投递返回ErrProcessorStopped，停止后可以再次调用StartProcess
+// StopDrain时ctx到期会放弃排空、尽快退出，并返回ctx.Err()
+// 在处理器goroutine中调用时不等待，当前回调返回之后处理循环退出
+func (p *Processor) Stop(ctx context.Context, mode StopMode) error {
+	if p.group != nil {
+		return p.group.Stop(ctx, mode)
+	}
+	done := p.beginStop(mode)
+	if p.OnLoop() {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		atomic.StoreInt32(&p.abort, 1)
+		return ctx.Err()
+	}
+}
+
+// drain 停止时处理队列中剩余的内容
+func (p *Processor) drain() {
+	for atomic.LoadInt32(&p.abort) == 0 {
+		select {
+		case msg := <-p.highChan:
+			p.HandleMessage(msg)
+		case msg := <-p.MessageChan:
+			p.HandleMessage(msg)
+		case msg := <-p.bulkChan:
+			p.HandleMessage(msg)
+		case event := <-p.EventChan:
+			if event.ID != ExitEvent {
+				p.handleEvent(event)
+			}
+		case f := <-p.FuncChan:
+			p.runFunc("func", f)
+		default:
+			if p.OverflowLen() == 0 {
+				return
+			}
+			p.runOverflow()
+		}
+	}
+}
+
+// Stop 停止所有分片
+func (g *ProcessorGroup) Stop(ctx context.Context, mode StopMode) error {
+	dones := make([]<-chan struct{}, len(g.shards))
+	for i, shard := range g.shards {
+		dones[i] = shard.beginStop(mode)
+	}
+	g.lifeMu.Lock()
+	idle := !g.running
+	g.lifeMu.Unlock()
+	if idle {
+		// 没有运行，分片已经直接进入停止状态
+		g.finish()
+		return nil
+	}
+	for _, done := range dones {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			for _, shard := range g.shards {
+				atomic.StoreInt32(&shard.abort, 1)
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Done 所有分片都退出时关闭，每个运行周期一个
+func (g *ProcessorGroup) Done() <-chan struct{} {
+	g.lifeMu.Lock()
+	defer g.lifeMu.Unlock()
+	return g.done
+}
@@ -0,0 +1,98 @@
+package network
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitRunning 等待处理循环启动，启动之前的Stop会让之后的StartProcess成为重新启动
+func waitRunning(p *Processor) {
+	for atomic.LoadInt32(&p.state) != procRunning {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestProcessorStop(t *testing.T) {
+	proc := NewProcessor()
+	handled := 0
+	proc.AddCallback(1, func(msg *Message) { handled++ })
+	for i := 0; i < 10; i++ {
+		proc.PostMessage(&Message{Head: MessageHead{ID: 1}})
+	}
+	go proc.StartProcess()
+	waitRunning(proc)
+
+	// 排空后退出
+	if err := proc.Stop(context.Background(), StopDrain); err != nil {
+		t.Fatal(err)
+	}
+	<-proc.Done()
+	if handled != 10 {
+		t.Fatalf("drain handled %d messages", handled)
+	}
+	if proc.PostMessage(&Message{Head: MessageHead{ID: 1}}) ||
+		proc.PostMessageWait(&Message{Head: MessageHead{ID: 1}}) != ErrProcessorStopped ||
+		proc.PostEventWait(&Event{ID: 100}) != ErrProcessorStopped {
+		t.Fatalf("stopped processor accepted a post")
+	}
+
+	// 重新启动
+	go proc.StartProcess()
+	waitRunning(proc)
+	if err := proc.PostMessageWait(&Message{Head: MessageHead{ID: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 排空超时后放弃排空，剩余内容保留到重新启动
+	block := make(chan struct{})
+	proc.FuncChan <- func() { <-block }
+	for i := 0; i < 5; i++ {
+		proc.PostMessage(&Message{Head: MessageHead{ID: 1}})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := proc.Stop(ctx, StopDrain); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	close(block)
+	<-proc.Done()
+	if handled+len(proc.MessageChan) != 16 {
+		t.Fatalf("messages lost: handled %d, queued %d", handled, len(proc.MessageChan))
+	}
+}
+
+func TestProcessorGroupStop(t *testing.T) {
+	g := NewProcessorGroup(4)
+	finished := make(chan struct{})
+	go func() {
+		g.StartProcess()
+		close(finished)
+	}()
+	for _, shard := range g.Shards() {
+		waitRunning(shard)
+	}
+	done := g.Processor().Done()
+	if done != g.Processor().Done() {
+		t.Fatal("Done should return the same channel during a run")
+	}
+	if err := g.Processor().Stop(context.Background(), StopNow); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	<-finished
+
+	// 再次启动使用新的Done
+	go g.StartProcess()
+	for _, shard := range g.Shards() {
+		waitRunning(shard)
+	}
+	select {
+	case <-g.Processor().Done():
+		t.Fatal("Done of the new run already closed")
+	default:
+	}
+	g.Processor().Stop(context.Background(), StopNow)
+	<-g.Processor().Done()
+}
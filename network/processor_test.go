@@ -3,6 +3,8 @@ package network
 import (
 	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -84,3 +86,146 @@ func TestWebSocket(t *testing.T) {
 	}
 	proc.StartProcess()
 }
+
+func TestProcessorGroupOrdering(t *testing.T) {
+	group := NewProcessorGroup(4)
+	entry := group.Processor()
+
+	const peers, perPeer = 8, 200
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(peers * perPeer)
+	received := make(map[uint64][]int32)
+	entry.AddCallback(1, func(msg *Message) {
+		mu.Lock()
+		received[msg.Peer.ConnID()] = append(received[msg.Peer.ConnID()], msg.Head.Length)
+		mu.Unlock()
+		wg.Done()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		group.StartProcess()
+		close(done)
+	}()
+
+	clients := make([]*ClientPeer, peers)
+	for i := range clients {
+		clients[i] = NewWebSocketClientPeer(&recordConn{}, entry)
+	}
+	for seq := int32(0); seq < perPeer; seq++ {
+		for _, c := range clients {
+			entry.PostMessageWait(&Message{Peer: c, Head: MessageHead{ID: 1, Length: seq}})
+		}
+	}
+	wg.Wait()
+
+	// 直接写入入口的通道由第一个分片处理
+	ran := make(chan bool)
+	entry.FuncChan <- func() { ran <- group.Shards()[0].OnLoop() }
+	if !<-ran {
+		t.Fatal("entry FuncChan not consumed by the first shard")
+	}
+	group.Exit("test")
+	<-done
+
+	for id, seqs := range received {
+		for i, seq := range seqs {
+			if int32(i) != seq {
+				t.Fatalf("peer %d out of order at %d: %v", id, i, seqs[:i+1])
+			}
+		}
+	}
+	if group.ShardForPeer(clients[0]) != entry.Owner(clients[0]) {
+		t.Fatalf("owner mismatch")
+	}
+}
+
+func TestProcessorMiddleware(t *testing.T) {
+	proc := NewProcessor()
+	var trace []string
+	mark := func(name string) Middleware {
+		return func(next MsgCallback) MsgCallback {
+			return func(msg *Message) {
+				trace = append(trace, name)
+				next(msg)
+			}
+		}
+	}
+	proc.AddCallback(5, func(msg *Message) { trace = append(trace, "h5") })
+	proc.AddCallback(50, func(msg *Message) { trace = append(trace, "h50") })
+	proc.UnHandledHandler = func(msg *Message) { trace = append(trace, "unhandled") }
+	proc.Use(mark("a"), mark("b"))
+	proc.UseRange(1, 10, mark("r"))
+	proc.Use(func(next MsgCallback) MsgCallback {
+		return func(msg *Message) {
+			if msg.Head.ID != 99 {
+				next(msg)
+			}
+		}
+	})
+
+	proc.ImmediateMode = true
+	proc.PostMessage(&Message{Head: MessageHead{ID: 5}})
+	proc.ImmediateMode = false
+	proc.PostMessage(&Message{Head: MessageHead{ID: 50}})
+	proc.PostMessage(&Message{Head: MessageHead{ID: 99}})
+	proc.HandleMessage(<-proc.MessageChan)
+	proc.HandleMessage(<-proc.MessageChan)
+
+	want := "a,b,r,h5,a,b,h50,a,b"
+	if got := strings.Join(trace, ","); got != want {
+		t.Fatalf("trace = %s, want %s", got, want)
+	}
+}
+
+func TestProcessorPanicIsolation(t *testing.T) {
+	proc := NewProcessor()
+	var reports []*PanicReport
+	proc.PanicHook = func(r *PanicReport) { reports = append(reports, r) }
+	proc.PanicPolicy = PanicQuarantine
+	proc.SetPanicBreaker(2, time.Minute, 0)
+
+	calls := 0
+	proc.AddCallback(7, func(msg *Message) {
+		calls++
+		panic("boom")
+	})
+	handled := make(chan struct{})
+	proc.AddCallback(8, func(msg *Message) { close(handled) })
+	peer := NewWebSocketClientPeer(&recordConn{}, proc)
+	other := NewWebSocketClientPeer(&recordConn{}, proc)
+
+	go func() {
+		proc.MessageChan <- &Message{Peer: peer, Head: MessageHead{ID: 7}, Body: []byte("abc")}
+		// 被隔离的peer的消息被丢弃
+		proc.MessageChan <- &Message{Peer: peer, Head: MessageHead{ID: 7}}
+		proc.MessageChan <- &Message{Peer: other, Head: MessageHead{ID: 7}}
+		// 熔断后不再调用
+		proc.MessageChan <- &Message{Peer: other, Head: MessageHead{ID: 7}}
+		proc.MessageChan <- &Message{Head: MessageHead{ID: 8}}
+		<-handled
+		proc.FuncChan <- func() { panic("func") }
+		proc.FuncChan <- func() {
+			proc.EventChan <- &Event{ID: ExitEvent}
+		}
+	}()
+	proc.StartProcess()
+
+	if calls != 2 || len(reports) != 3 {
+		t.Fatalf("calls = %d, reports = %d", calls, len(reports))
+	}
+	if r := reports[0]; r.Kind != "message" || r.MsgID != 7 || r.PayloadSize != 3 || r.Peer != peer || len(r.Stack) == 0 {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	if !reports[1].Disabled || !proc.HandlerDisabled(7) || reports[2].Kind != "func" {
+		t.Fatalf("breaker not triggered")
+	}
+	if !peer.Session().Quarantined() {
+		t.Fatalf("peer not quarantined")
+	}
+	proc.ResetPanicBreaker(7)
+	if proc.HandlerDisabled(7) {
+		t.Fatalf("breaker not reset")
+	}
+}
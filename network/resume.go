@@ -0,0 +1,382 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+/*
+可恢复会话
+
+ 1. 登录成功后调用ResumeManager.Enable(peer)获取token，发给客户端
+ 2. Enable之后发送给这个会话的每一帧都有一个递增的序号(从1开始)，
+    并保存在有限长度的重放缓冲中，客户端需要统计收到的帧数
+ 3. 连接断开时会话进入挂起状态，处理器收到SuspendEvent而不是RemoveEvent，
+    挂起期间发送的消息只进入重放缓冲
+ 4. 客户端重连后发送token和已收到的帧数，应用调用ResumeManager.Resume把会话转移到新的peer，
+    缺失的帧会被重放，处理器收到ResumeEvent
+ 5. 超过宽限期没有恢复时，执行会话清理函数，处理器收到RemoveEvent
+
+会话恢复后，旧的ClientPeer上的发送会转发到新的peer，所以应用持有的旧引用依然可用
+*/
+
+// 会话恢复错误
+var (
+	ErrResumeTokenInvalid = errors.New("resume token invalid or expired")
+	ErrResumeGap          = errors.New("missed messages no longer buffered")
+	ErrSessionEnded       = errors.New("session ended")
+)
+
+type replayFrame struct {
+	seq   uint64
+	frame []byte
+}
+
+// resumeState 可恢复会话的状态
+// wmu保证帧按序号写出，mu保护状态，写入时不持有mu，先加wmu再加mu
+type resumeState struct {
+	wmu     sync.Mutex
+	mu      sync.Mutex
+	manager *ResumeManager
+	session *Session
+	token   string
+	seq     uint64
+	frames  []replayFrame
+
+	current     *AsyncClientPeer // 当前连接的peer，挂起时为nil
+	suspendedBy *AsyncClientPeer // 最后断开的peer
+	timer       *time.Timer
+	generation  uint64 // 每次挂起加1，过期的宽限期定时器不再生效
+	ended       bool
+	replaying   bool // Resume正在重放，新的帧由重放写出
+}
+
+// send 记录一帧并发送给当前peer，挂起或者正在重放时只记录
+func (rs *resumeState) send(pm *PreparedMessage) error {
+	rs.wmu.Lock()
+	defer rs.wmu.Unlock()
+	rs.mu.Lock()
+	if rs.ended {
+		rs.mu.Unlock()
+		return ErrSessionEnded
+	}
+	rs.seq++
+	rs.frames = append(rs.frames, replayFrame{
+		seq:   rs.seq,
+		frame: append([]byte(nil), pm.Bytes()...),
+	})
+	// 重放期间不丢弃旧帧，否则Resume检查过的帧可能在重放之前被挤出缓冲
+	if over := len(rs.frames) - rs.manager.replaySize; over > 0 && !rs.replaying {
+		rs.frames = append(rs.frames[:0], rs.frames[over:]...)
+	}
+	current := rs.current
+	if rs.replaying {
+		current = nil
+	}
+	rs.mu.Unlock()
+	if current == nil {
+		return nil
+	}
+	return current.sendPreparedDirect(pm)
+}
+
+// missing 复制序号在lastSeq之后的帧，结束重放状态，缓冲中已经没有lastSeq+1时返回ErrResumeGap
+func (rs *resumeState) missing(lastSeq uint64) ([][]byte, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.replaying = false
+	if lastSeq < rs.seq && (len(rs.frames) == 0 || rs.frames[0].seq > lastSeq+1) {
+		return nil, ErrResumeGap
+	}
+	var frames [][]byte
+	for _, f := range rs.frames {
+		if f.seq > lastSeq {
+			frames = append(frames, f.frame)
+		}
+	}
+	if over := len(rs.frames) - rs.manager.replaySize; over > 0 {
+		rs.frames = append(rs.frames[:0:0], rs.frames[over:]...)
+	}
+	return frames, nil
+}
+
+// detach peer关闭时调用，返回true表示会话结束
+func (rs *resumeState) detach(peer *AsyncClientPeer) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.ended {
+		return true
+	}
+	if rs.current != peer {
+		// 会话已经转移到其他peer
+		return false
+	}
+	rs.current = nil
+	rs.suspendedBy = peer
+	rs.generation++
+	generation := rs.generation
+	rs.timer = time.AfterFunc(rs.manager.grace, func() { rs.expire(generation) })
+	return false
+}
+
+// leaveEventID peer断开时应该投递的事件，0表示不投递
+func (rs *resumeState) leaveEventID(peer *AsyncClientPeer) int32 {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	switch {
+	case rs.ended:
+		return RemoveEvent
+	case rs.current == nil && rs.suspendedBy == peer:
+		return SuspendEvent
+	case rs.current != peer:
+		return 0
+	}
+	return RemoveEvent
+}
+
+// end 结束会话，返回最后关联的peer
+func (rs *resumeState) end() (*AsyncClientPeer, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.endLocked()
+}
+
+// endLocked 结束会话，调用者持有mu
+func (rs *resumeState) endLocked() (*AsyncClientPeer, bool) {
+	if rs.ended {
+		return nil, false
+	}
+	rs.ended = true
+	rs.frames = nil
+	if rs.timer != nil {
+		rs.timer.Stop()
+		rs.timer = nil
+	}
+	rs.manager.remove(rs.token)
+	if rs.current != nil {
+		return rs.current, false
+	}
+	return rs.suspendedBy, true
+}
+
+// expire 宽限期到达，generation为定时器创建时的挂起次数
+// 定时器已经触发但还在等待mu时，Resume的Stop无法阻止它，这时会话已经恢复或者再次挂起，不能结束
+func (rs *resumeState) expire(generation uint64) {
+	rs.mu.Lock()
+	if rs.current != nil || rs.generation != generation {
+		rs.mu.Unlock()
+		return
+	}
+	peer, suspended := rs.endLocked()
+	rs.mu.Unlock()
+	if !suspended || peer == nil {
+		return
+	}
+	base.Zap().Sugar().Debugf("resumable session %d expired", peer.connID)
+	rs.session.runCloseHooks(&ClientPeer{AsyncClientPeer: peer})
+	postPeerEvent(peer, peer.leaveEvent(RemoveEvent))
+}
+
+// ResumeManager 可恢复会话管理
+type ResumeManager struct {
+	mu         sync.Mutex
+	sessions   map[string]*resumeState
+	grace      time.Duration
+	replaySize int
+}
+
+// NewResumeManager 创建会话恢复管理器，grace为断线后保留会话的时间，replaySize为最多保留的帧数
+func NewResumeManager(grace time.Duration, replaySize int) *ResumeManager {
+	if replaySize <= 0 {
+		replaySize = 256
+	}
+	return &ResumeManager{
+		sessions:   make(map[string]*resumeState),
+		grace:      grace,
+		replaySize: replaySize,
+	}
+}
+
+func (rm *ResumeManager) remove(token string) {
+	rm.mu.Lock()
+	delete(rm.sessions, token)
+	rm.mu.Unlock()
+}
+
+// Enable 开启peer的会话恢复，返回token，已经开启时返回原token
+func (rm *ResumeManager) Enable(peer *ClientPeer) (string, error) {
+	s := peer.Session()
+	if rs := s.resumeState(); rs != nil {
+		return rs.token, nil
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	rs := &resumeState{
+		manager: rm,
+		session: s,
+		token:   hex.EncodeToString(raw),
+		current: peer.AsyncClientPeer,
+	}
+
+	rm.mu.Lock()
+	rm.sessions[rs.token] = rs
+	rm.mu.Unlock()
+
+	s.mu.Lock()
+	s.resume = rs
+	s.mu.Unlock()
+	return rs.token, nil
+}
+
+// Discard 关闭peer的会话恢复，挂起中的会话立即结束
+// 踢人、主动登出等不需要恢复的场景，应该在Close之前调用
+func (rm *ResumeManager) Discard(peer *ClientPeer) {
+	peer.Session().discardResume()
+}
+
+// discardResume 关闭会话恢复，挂起中的会话立即结束
+func (s *Session) discardResume() {
+	rs := s.resumeState()
+	if rs == nil {
+		return
+	}
+	last, suspended := rs.end()
+	s.mu.Lock()
+	s.resume = nil
+	s.mu.Unlock()
+	if suspended && last != nil {
+		s.runCloseHooks(&ClientPeer{AsyncClientPeer: last})
+		postPeerEvent(last, last.leaveEvent(RemoveEvent))
+	}
+}
+
+// Resume 把token对应的会话转移到新连接，lastSeq为客户端已经收到的帧数
+// 成功后新peer使用原会话和原ID，缺失的帧被重放，处理器收到ResumeEvent
+// 如果原连接还没有断开，会被直接关闭，不会产生SuspendEvent或RemoveEvent
+// 重放写入失败时新peer被关闭，会话重新挂起，可以再次恢复
+func (rm *ResumeManager) Resume(peer *ClientPeer, token string, lastSeq uint64) error {
+	rm.mu.Lock()
+	rs, ok := rm.sessions[token]
+	rm.mu.Unlock()
+	if !ok {
+		return ErrResumeTokenInvalid
+	}
+
+	rs.mu.Lock()
+	if rs.ended {
+		rs.mu.Unlock()
+		return ErrResumeTokenInvalid
+	}
+	if rs.current == peer.AsyncClientPeer {
+		// 已经在这个连接上
+		rs.mu.Unlock()
+		return nil
+	}
+	if lastSeq > rs.seq {
+		rs.mu.Unlock()
+		return ErrResumeTokenInvalid
+	}
+	if lastSeq < rs.seq && (len(rs.frames) == 0 || rs.frames[0].seq > lastSeq+1) {
+		rs.mu.Unlock()
+		return ErrResumeGap
+	}
+	if rs.timer != nil {
+		rs.timer.Stop()
+		rs.timer = nil
+	}
+
+	old := rs.current
+	if old == nil {
+		old = rs.suspendedBy
+	}
+	rs.current = peer.AsyncClientPeer
+	rs.suspendedBy = nil
+	// 重放完成之前新的帧只记录，由重放按顺序写出
+	rs.replaying = true
+
+	// 新peer继承原会话和身份，在会话锁内替换，和会话上的其他操作互斥
+	rs.session.mu.Lock()
+	fresh := (*Session)(atomic.SwapPointer(&peer.session, unsafe.Pointer(rs.session)))
+	if old != nil {
+		atomic.StoreInt32(&peer.Flag, atomic.LoadInt32(&old.Flag))
+	}
+	rs.session.mu.Unlock()
+	if old != nil {
+		if peer.manager != nil {
+			peer.manager.BindID(peer, atomic.LoadInt64(&old.ID))
+			peer.manager.resumed(rs.session, peer.AsyncClientPeer)
+		} else {
+			atomic.StoreInt64(&peer.ID, atomic.LoadInt64(&old.ID))
+		}
+	}
+	rs.mu.Unlock()
+
+	// 原连接还没有断开时先关闭，让正在写它的send返回
+	if old != nil && old.GetState() == PeerStateConnected {
+		old.Close()
+	}
+
+	// 新连接原本的会话不再使用
+	fresh.runCloseHooks(peer)
+
+	// 重放缺失的帧，复制出来之后再写，写入时不持有mu
+	rs.wmu.Lock()
+	frames, err := rs.missing(lastSeq)
+	for _, f := range frames {
+		if err = peer.sendFrameDirect(f); err != nil {
+			break
+		}
+	}
+	rs.wmu.Unlock()
+	if err != nil {
+		// 会话已经转移到新peer，关闭它让会话重新挂起，缺失的帧无法补齐时直接结束会话
+		if err == ErrResumeGap {
+			rs.end()
+		}
+		peer.SetDisconnect(DisconnectError, err)
+		peer.Close()
+		return err
+	}
+
+	postPeerEvent(peer.AsyncClientPeer, &Event{
+		ID:   ResumeEvent,
+		Peer: peer,
+	})
+	return nil
+}
+
+// resumeState 可恢复状态，未开启时为nil
+func (s *Session) resumeState() *resumeState {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.resume
+}
+
+// Resumable 是否开启了会话恢复
+func (s *Session) Resumable() bool {
+	return s.resumeState() != nil
+}
+
+// Seq 已经发送的帧数，未开启会话恢复时为0
+func (s *Session) Seq() uint64 {
+	rs := s.resumeState()
+	if rs == nil {
+		return 0
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.seq
+}
@@ -0,0 +1,178 @@
+package network
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testFrame(id int32) []byte {
+	pm, _ := NewPreparedMessageFromBytes(id, nil)
+	defer pm.Release()
+	return append([]byte(nil), pm.Bytes()...)
+}
+
+func TestSessionResume(t *testing.T) {
+	proc := NewProcessor()
+	rm := NewResumeManager(time.Minute, 16)
+	c1, c2 := &recordConn{}, &recordConn{}
+	p1 := NewWebSocketClientPeer(c1, proc)
+	proc.Peers.BindID(p1, 42)
+	Set(p1.Session(), "gold", 100)
+
+	token, err := rm.Enable(p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int32(1); i <= 3; i++ {
+		p1.SendMessageBuffer(testFrame(i))
+	}
+
+	p1.Close()
+	if ev := p1.LeaveEvent(); ev == nil || ev.ID != SuspendEvent {
+		t.Fatalf("expected suspend event, got %v", ev)
+	}
+	// 挂起期间的消息进入重放缓冲
+	if err := p1.SendMessageBuffer(testFrame(4)); err != nil {
+		t.Fatal(err)
+	}
+
+	p2 := NewWebSocketClientPeer(c2, proc)
+	if err := rm.Resume(p2, token, 2); err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-proc.EventChan; ev.ID != ResumeEvent || ev.Peer.AsyncClientPeer != p2.AsyncClientPeer {
+		t.Fatalf("expected resume event")
+	}
+	if v, _ := Get[int](p2.Session(), "gold"); v != 100 || p2.ID != 42 || proc.Peers.GetByID(42) == nil {
+		t.Fatalf("session not transferred")
+	}
+	if c2.count() != 2 || c2.writes[0][4] != 3 || c2.writes[1][4] != 4 {
+		t.Fatalf("unexpected replay %v", c2.writes)
+	}
+
+	// 旧引用转发到新连接
+	p1.SendMessageBuffer(testFrame(5))
+	if c2.count() != 3 || c1.count() != 3 {
+		t.Fatalf("unexpected writes %d %d", c1.count(), c2.count())
+	}
+	if err := rm.Resume(p2, token, 0); err != nil || p2.GetState() != PeerStateConnected {
+		t.Fatalf("resume onto the attached peer should be a no-op: %v", err)
+	}
+}
+
+func TestSessionResumeExpire(t *testing.T) {
+	proc := NewProcessor()
+	rm := NewResumeManager(10*time.Millisecond, 16)
+	p := NewWebSocketClientPeer(&recordConn{}, proc)
+	closed := make(chan struct{})
+	p.Session().OnClose(func(*ClientPeer) { close(closed) })
+	token, _ := rm.Enable(p)
+
+	p.Close()
+	select {
+	case <-closed:
+		t.Fatalf("close hooks should wait for grace period")
+	default:
+	}
+	if ev := <-proc.EventChan; ev.ID != RemoveEvent {
+		t.Fatalf("expected remove event after expiry, got %d", ev.ID)
+	}
+	<-closed
+	if err := rm.Resume(NewWebSocketClientPeer(&recordConn{}, proc), token, 0); err != ErrResumeTokenInvalid {
+		t.Fatalf("expired token accepted: %v", err)
+	}
+}
+
+func TestSessionResumeOrdering(t *testing.T) {
+	group := NewProcessorGroup(8)
+	entry := group.Processor()
+	rm := NewResumeManager(time.Minute, 256)
+	p1 := NewWebSocketClientPeer(&recordConn{}, entry)
+	token, _ := rm.Enable(p1)
+	shard := group.ShardForPeer(p1)
+	p1.Close()
+
+	// 恢复期间其他goroutine继续发送，新连接按序号收到所有的帧
+	c2 := &recordConn{}
+	p2 := NewWebSocketClientPeer(c2, entry)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := int32(1); i <= 100; i++ {
+			p1.SendMessageBuffer(testFrame(i))
+		}
+	}()
+	if err := rm.Resume(p2, token, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	c2.mu.Lock()
+	defer c2.mu.Unlock()
+	if len(c2.writes) != 100 {
+		t.Fatalf("received %d frames", len(c2.writes))
+	}
+	for i, w := range c2.writes {
+		if int(w[4]) != i+1 {
+			t.Fatalf("frame %d out of order: %d", i+1, w[4])
+		}
+	}
+	// 恢复后仍然由原来的分片处理
+	if group.ShardForPeer(p2) != shard {
+		t.Fatal("resumed peer moved to another shard")
+	}
+}
+
+type failConn struct{ recordConn }
+
+func (c *failConn) Write(b []byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestSessionResumeStaleExpire(t *testing.T) {
+	proc := NewProcessor()
+	rm := NewResumeManager(time.Minute, 16)
+	p1 := NewWebSocketClientPeer(&recordConn{}, proc)
+	token, _ := rm.Enable(p1)
+	rs := p1.Session().resumeState()
+	p1.Close()
+
+	// 定时器已经触发但等待锁时会话被恢复，过期不能结束恢复后的会话
+	rs.mu.Lock()
+	generation := rs.generation
+	rs.mu.Unlock()
+	p2 := NewWebSocketClientPeer(&recordConn{}, proc)
+	if err := rm.Resume(p2, token, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-proc.EventChan
+	rs.expire(generation)
+	p2.Close()
+	rs.expire(generation)
+	if p2.Session().Seq() != 0 || rs.ended {
+		t.Fatalf("stale timer ended the session")
+	}
+}
+
+func TestSessionResumeReplayFailure(t *testing.T) {
+	proc := NewProcessor()
+	rm := NewResumeManager(time.Minute, 16)
+	p1 := NewWebSocketClientPeer(&recordConn{}, proc)
+	token, _ := rm.Enable(p1)
+	p1.SendMessageBuffer(testFrame(1))
+	p1.Close()
+
+	// 重放失败时新连接被关闭，会话重新挂起，可以在另一个连接上恢复
+	p2 := NewWebSocketClientPeer(&failConn{}, proc)
+	if err := rm.Resume(p2, token, 0); err == nil {
+		t.Fatal("replay failure not reported")
+	}
+	if p2.GetState() == PeerStateConnected {
+		t.Fatal("half resumed peer left open")
+	}
+	if ev := p2.LeaveEvent(); ev == nil || ev.ID != SuspendEvent {
+		t.Fatalf("expected suspend event, got %v", ev)
+	}
+	c3 := &recordConn{}
+	if err := rm.Resume(NewWebSocketClientPeer(c3, proc), token, 0); err != nil || c3.count() != 1 {
+		t.Fatalf("resume after failed replay: %v", err)
+	}
+}
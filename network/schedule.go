@@ -0,0 +1,168 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+// CatchUpPolicy 错过的触发（停服、处理器阻塞）如何补偿
+type CatchUpPolicy int
+
+const (
+	// CatchUpSkip 丢弃错过的触发
+	CatchUpSkip CatchUpPolicy = iota
+	// CatchUpOnce 错过多次只补一次，使用最近一次错过的时间
+	CatchUpOnce
+	// CatchUpAll 按顺序补上每一次错过的触发
+	CatchUpAll
+)
+
+// ScheduleOptions 定时任务选项
+type ScheduleOptions struct {
+	CatchUp CatchUpPolicy
+	// LastRun 上次处理过的触发时间，一般从存储中读取，用于停服后补偿，零值表示不补偿
+	LastRun time.Time
+	// MisfireGrace 触发时间过去多久以内算准时，默认1分钟
+	MisfireGrace time.Duration
+	// MaxCatchUp CatchUpAll时最多补偿的次数，0表示不限制
+	MaxCatchUp int
+}
+
+// ScheduleFunc 定时任务，at是这次的触发时间
+type ScheduleFunc func(at time.Time)
+
+// maxScheduleWait 定时任务单次等待的最长时间，避免系统时间调整后长时间不触发
+const maxScheduleWait = time.Hour
+
+// ScheduledJob 按日历规则在处理器上执行的任务
+type ScheduledJob struct {
+	proc     *Processor
+	schedule base.Schedule
+	fn       ScheduleFunc
+	opts     ScheduleOptions
+
+	mu      sync.Mutex
+	next    time.Time
+	last    time.Time
+	timer   *Timer
+	stopped bool
+}
+
+// Schedule 按规则在处理器goroutine中执行f，opts可以为nil
+func (p *Processor) Schedule(s base.Schedule, f ScheduleFunc, opts *ScheduleOptions) *ScheduledJob {
+	j := &ScheduledJob{
+		proc:     p.timerOwner(nil),
+		schedule: s,
+		fn:       f,
+	}
+	if opts != nil {
+		j.opts = *opts
+	}
+	if j.opts.MisfireGrace <= 0 {
+		j.opts.MisfireGrace = time.Minute
+	}
+	j.mu.Lock()
+	if j.opts.LastRun.IsZero() {
+		j.next = s.Next(time.Now())
+	} else {
+		j.last = j.opts.LastRun
+		j.next = s.Next(j.opts.LastRun)
+	}
+	j.arm()
+	j.mu.Unlock()
+	return j
+}
+
+// ScheduleSpec 解析规则后调用Schedule，规则格式见base.ParseSchedule
+func (p *Processor) ScheduleSpec(spec string, f ScheduleFunc, opts *ScheduleOptions) (*ScheduledJob, error) {
+	s, err := base.ParseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+	return p.Schedule(s, f, opts), nil
+}
+
+// arm 设置下一次定时器，需要加锁
+func (j *ScheduledJob) arm() {
+	if j.stopped || j.next.IsZero() {
+		return
+	}
+	d := time.Until(j.next)
+	if d > maxScheduleWait {
+		d = maxScheduleWait
+	}
+	j.timer = j.proc.AfterFunc(d, j.fire)
+}
+
+// fire 在处理器goroutine中执行
+func (j *ScheduledJob) fire() {
+	now := time.Now()
+	j.mu.Lock()
+	if j.stopped {
+		j.mu.Unlock()
+		return
+	}
+	var missed, onTime []time.Time
+	t := j.next
+	for !t.IsZero() && !t.After(now) {
+		if now.Sub(t) <= j.opts.MisfireGrace {
+			onTime = append(onTime, t)
+		} else if j.opts.CatchUp == CatchUpAll {
+			missed = append(missed, t)
+		} else {
+			missed = append(missed[:0], t)
+		}
+		j.last = t
+		t = j.schedule.Next(t)
+	}
+	j.next = t
+	j.arm()
+	j.mu.Unlock()
+
+	var runs []time.Time
+	switch j.opts.CatchUp {
+	case CatchUpOnce:
+		if len(onTime) == 0 {
+			runs = missed
+		}
+	case CatchUpAll:
+		if j.opts.MaxCatchUp > 0 && len(missed) > j.opts.MaxCatchUp {
+			missed = missed[len(missed)-j.opts.MaxCatchUp:]
+		}
+		runs = missed
+	}
+	runs = append(runs, onTime...)
+	for _, at := range runs {
+		at := at
+		j.proc.runFunc("schedule", func() { j.fn(at) })
+	}
+}
+
+// Next 下一次触发时间，可以用于界面倒计时，任务停止或者没有下一次时返回零值
+func (j *ScheduledJob) Next() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stopped {
+		return time.Time{}
+	}
+	return j.next
+}
+
+// LastRun 最近处理过的触发时间，包括按策略跳过的，保存下来在重启时传给ScheduleOptions.LastRun
+func (j *ScheduledJob) LastRun() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.last
+}
+
+// Stop 停止任务，可以在任意goroutine中调用
+func (j *ScheduledJob) Stop() {
+	j.mu.Lock()
+	j.stopped = true
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+	j.mu.Unlock()
+}
@@ -0,0 +1,58 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+// fixedSchedule 固定触发时间，用于测试
+type fixedSchedule []time.Time
+
+func (s fixedSchedule) Next(after time.Time) time.Time {
+	for _, t := range s {
+		if t.After(after) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func TestScheduleCatchUp(t *testing.T) {
+	now := time.Now()
+	s := fixedSchedule{now.Add(-3 * time.Hour), now.Add(-2 * time.Hour), now.Add(-time.Hour), now.Add(time.Hour)}
+	cases := []struct {
+		policy CatchUpPolicy
+		want   []time.Time
+	}{
+		{CatchUpSkip, nil},
+		{CatchUpOnce, s[2:3]},
+		{CatchUpAll, s[:3]},
+	}
+	for _, c := range cases {
+		proc := NewProcessor()
+		go proc.StartProcess()
+		var runs []time.Time
+		job := proc.Schedule(s, func(at time.Time) { runs = append(runs, at) },
+			&ScheduleOptions{CatchUp: c.policy, LastRun: now.Add(-4 * time.Hour)})
+		deadline := time.Now().Add(time.Second)
+		for !job.Next().Equal(s[3]) && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		done := make(chan []time.Time)
+		proc.FuncChan <- func() { done <- runs }
+		got := <-done
+		if len(got) != len(c.want) || !job.LastRun().Equal(s[2]) {
+			t.Fatalf("policy %d: got %v, want %v", c.policy, got, c.want)
+		}
+		for i := range got {
+			if !got[i].Equal(c.want[i]) {
+				t.Fatalf("policy %d: got %v, want %v", c.policy, got, c.want)
+			}
+		}
+		job.Stop()
+		if !job.Next().IsZero() {
+			t.Fatalf("stopped job should have no next fire time")
+		}
+		proc.PostEventWait(&Event{ID: ExitEvent})
+	}
+}
@@ -0,0 +1,243 @@
+package network
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Principal 已认证的身份信息
+type Principal struct {
+	ID     int64
+	Name   string
+	Roles  []string
+	Scopes []string
+}
+
+// Session 每个peer独立的会话，TCP、KCP、WebSocket共用
+// 所有方法都是线程安全的，可以在reactor、处理器等任意goroutine中调用
+type Session struct {
+	id        uint64 // 处理器组按会话分片
+	mu        sync.RWMutex
+	attrs     map[string]interface{}
+	principal *Principal
+	createdAt time.Time
+	loginAt   time.Time
+
+	// 被隔离的peer消息不再分发
+	quarantined bool
+
+	// 关闭时执行的清理函数，按注册顺序执行
+	closeHooks []closeHook
+	nextHookID uint64
+	closed     bool
+	closedPeer *ClientPeer
+
+	// 会话恢复，未开启时为nil
+	resume *resumeState
+}
+
+type closeHook struct {
+	id uint64
+	fn func(peer *ClientPeer)
+}
+
+// nextSessionID 会话ID，进程内唯一
+var nextSessionID uint64
+
+// NewSession 创建会话
+func NewSession() *Session {
+	return &Session{
+		id:        atomic.AddUint64(&nextSessionID, 1),
+		attrs:     make(map[string]interface{}),
+		createdAt: time.Now(),
+	}
+}
+
+// Get 获取指定类型的属性，类型不匹配或不存在时返回零值和false
+func Get[T any](s *Session, key string) (T, bool) {
+	var zero T
+	if s == nil {
+		return zero, false
+	}
+	s.mu.RLock()
+	v, ok := s.attrs[key]
+	s.mu.RUnlock()
+	if !ok {
+		return zero, false
+	}
+	t, ok := v.(T)
+	if !ok {
+		return zero, false
+	}
+	return t, true
+}
+
+// Set 设置属性，s为nil时忽略，和Get一致
+func Set[T any](s *Session, key string, value T) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// Delete 删除属性
+func (s *Session) Delete(key string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.attrs, key)
+	s.mu.Unlock()
+}
+
+// Keys 返回所有属性名
+func (s *Session) Keys() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.attrs))
+	for k := range s.attrs {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Login 绑定已认证的身份，并记录登录时间
+func (s *Session) Login(principal *Principal) {
+	s.mu.Lock()
+	s.principal = principal
+	s.loginAt = time.Now()
+	s.mu.Unlock()
+}
+
+// Logout 解除身份绑定
+func (s *Session) Logout() {
+	s.mu.Lock()
+	s.principal = nil
+	s.loginAt = time.Time{}
+	s.mu.Unlock()
+}
+
+// Principal 当前身份，未登录时返回nil
+func (s *Session) Principal() *Principal {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.principal
+}
+
+// Authenticated 是否已经登录
+func (s *Session) Authenticated() bool {
+	return s.Principal() != nil
+}
+
+// CreatedAt 会话创建时间
+func (s *Session) CreatedAt() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return s.createdAt
+}
+
+// LoginAt 登录时间，未登录时为零值
+func (s *Session) LoginAt() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loginAt
+}
+
+// Quarantine 隔离会话，之后收到的消息都被丢弃
+func (s *Session) Quarantine() {
+	s.mu.Lock()
+	s.quarantined = true
+	s.mu.Unlock()
+}
+
+// Quarantined 是否被隔离
+func (s *Session) Quarantined() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.quarantined
+}
+
+// OnClose 注册peer关闭时执行的清理函数，返回的id可以用于RemoveCloseHook
+// 清理函数在关闭连接的goroutine中执行，如果会话已经关闭则立即执行，s为nil时忽略并返回0
+func (s *Session) OnClose(hook func(peer *ClientPeer)) uint64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	if s.closed {
+		peer := s.closedPeer
+		s.mu.Unlock()
+		hook(peer)
+		return 0
+	}
+	s.nextHookID++
+	id := s.nextHookID
+	s.closeHooks = append(s.closeHooks, closeHook{id: id, fn: hook})
+	s.mu.Unlock()
+	return id
+}
+
+// RemoveCloseHook 删除清理函数
+func (s *Session) RemoveCloseHook(id uint64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	for i, h := range s.closeHooks {
+		if h.id == id {
+			s.closeHooks = append(s.closeHooks[:i], s.closeHooks[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+}
+
+// isClosed 会话是否已经结束，清理函数已经执行
+func (s *Session) isClosed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.closed
+}
+
+// detach peer关闭时调用，返回true表示会话结束，需要执行清理函数
+func (s *Session) detach(peer *AsyncClientPeer) bool {
+	rs := s.resumeState()
+	if rs == nil {
+		return true
+	}
+	return rs.detach(peer)
+}
+
+// runCloseHooks 执行所有清理函数，只会执行一次
+func (s *Session) runCloseHooks(peer *ClientPeer) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.closedPeer = peer
+	hooks := s.closeHooks
+	s.closeHooks = nil
+	s.mu.Unlock()
+
+	for _, h := range hooks {
+		h.fn(peer)
+	}
+}
@@ -0,0 +1,57 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSessionAttributes(t *testing.T) {
+	s := NewSession()
+	Set(s, "level", 10)
+	Set(s, "name", "player")
+
+	if v, ok := Get[int](s, "level"); !ok || v != 10 {
+		t.Fatalf("level = %v,%v", v, ok)
+	}
+	if _, ok := Get[string](s, "level"); ok {
+		t.Fatalf("type mismatch should fail")
+	}
+	s.Delete("name")
+	if _, ok := Get[string](s, "name"); ok {
+		t.Fatalf("deleted attribute still present")
+	}
+
+	// 没有会话时Get和Set都不panic
+	var peer *AsyncClientPeer
+	Set(peer.Session(), "level", 1)
+	if _, ok := Get[int](peer.Session(), "level"); ok {
+		t.Fatalf("nil session returned a value")
+	}
+	var nilSession *Session
+	if nilSession.Principal() != nil || nilSession.Authenticated() || !nilSession.LoginAt().IsZero() ||
+		nilSession.Quarantined() || nilSession.Resumable() {
+		t.Fatalf("nil session reported state")
+	}
+	if id := nilSession.OnClose(func(*ClientPeer) { t.Error("hook on nil session executed") }); id != 0 {
+		t.Fatalf("unexpected hook id %d", id)
+	}
+	nilSession.RemoveCloseHook(1)
+}
+
+func TestSessionCloseHooks(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	peer := NewWebSocketClientPeer(c1, NewProcessor())
+
+	var order []int
+	peer.Session().OnClose(func(p *ClientPeer) { order = append(order, 1) })
+	id := peer.Session().OnClose(func(p *ClientPeer) { order = append(order, 2) })
+	peer.Session().OnClose(func(p *ClientPeer) { order = append(order, 3) })
+	peer.Session().RemoveCloseHook(id)
+
+	peer.Close()
+	peer.Close()
+	if len(order) != 2 || order[0] != 1 || order[1] != 3 {
+		t.Fatalf("unexpected hook order %v", order)
+	}
+}
@@ -0,0 +1,465 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+/*
+Supervisor 监督树
+
+  - 子节点按添加顺序启动，前一个Start返回之后才启动下一个
+  - 子节点退出后按RestartPolicy决定是否重启，OneForOne只重启退出的子节点，
+    AllForOne逆序停止其他子节点后按顺序全部重启
+  - Period时间内重启超过MaxRestarts次时放弃，逆序停止所有子节点，Run返回ErrRestartIntensity
+  - Stop按添加顺序的逆序停止子节点，Supervisor本身也可以作为子节点组成树
+*/
+type Supervisor struct {
+	Name     string
+	Strategy SupervisorStrategy
+	// MaxRestarts Period时间内允许的最多重启次数
+	MaxRestarts int
+	Period      time.Duration
+	// ShutdownTimeout 停止单个子节点的超时时间，0表示一直等待
+	ShutdownTimeout time.Duration
+
+	children []*supervisedChild
+	exits    chan childExit
+	done     chan struct{} // Run返回时关闭，停止超时的子节点之后退出时不再发送
+	stopCh   chan context.Context
+	stopped  chan error
+
+	mu          sync.Mutex
+	running     bool
+	stopPending bool // Run之前调用了Stop
+	restarts    []time.Time
+}
+
+// SupervisorStrategy 重启策略
+type SupervisorStrategy int
+
+const (
+	// OneForOne 只重启退出的子节点
+	OneForOne SupervisorStrategy = iota
+	// AllForOne 一个子节点退出时重启所有子节点
+	AllForOne
+)
+
+// RestartPolicy 子节点什么时候需要重启
+type RestartPolicy int
+
+const (
+	// RestartPermanent 总是重启
+	RestartPermanent RestartPolicy = iota
+	// RestartTransient 出错或者panic退出时重启，正常退出不重启
+	RestartTransient
+	// RestartTemporary 从不重启
+	RestartTemporary
+)
+
+// ErrRestartIntensity 重启太频繁，监督树放弃
+var ErrRestartIntensity = errors.New("supervisor: restart intensity exceeded")
+
+// ErrSupervisorRunning 监督树已经在运行
+var ErrSupervisorRunning = errors.New("supervisor: already running")
+
+// ChildSpec 子节点
+type ChildSpec struct {
+	Name    string
+	Restart RestartPolicy
+	// Start 同步启动，返回nil表示子节点已经可用，可以为nil
+	Start func() error
+	// Run 阻塞运行，返回表示子节点退出，nil表示正常退出
+	Run func() error
+	// Stop 让Run尽快返回
+	Stop func(ctx context.Context) error
+}
+
+type supervisedChild struct {
+	spec    ChildSpec
+	gen     int
+	running bool
+}
+
+type childExit struct {
+	index int
+	gen   int
+	err   error
+}
+
+// NewSupervisor 创建监督树，period时间内重启超过maxRestarts次时放弃
+func NewSupervisor(name string, strategy SupervisorStrategy, maxRestarts int, period time.Duration) *Supervisor {
+	return &Supervisor{
+		Name:            name,
+		Strategy:        strategy,
+		MaxRestarts:     maxRestarts,
+		Period:          period,
+		ShutdownTimeout: 10 * time.Second,
+	}
+}
+
+// Add 添加子节点，必须在Run之前调用
+func (s *Supervisor) Add(spec ...ChildSpec) *Supervisor {
+	for _, c := range spec {
+		s.children = append(s.children, &supervisedChild{spec: c})
+	}
+	return s
+}
+
+// Run 按顺序启动所有子节点并监控，阻塞到Stop或者重启太频繁
+// 启动失败时逆序停止已经启动的子节点并返回错误
+// 之前已经调用过Stop时不启动子节点，直接返回nil
+func (s *Supervisor) Run() error {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return ErrSupervisorRunning
+	}
+	if s.stopPending {
+		s.stopPending = false
+		s.mu.Unlock()
+		return nil
+	}
+	s.running = true
+	s.restarts = nil
+	s.exits = make(chan childExit, len(s.children))
+	s.done = make(chan struct{})
+	s.stopCh = make(chan context.Context)
+	s.stopped = make(chan error, 1)
+	stopCh, stopped := s.stopCh, s.stopped
+	s.mu.Unlock()
+
+	err := s.supervise(stopCh)
+	s.mu.Lock()
+	s.running = false
+	s.mu.Unlock()
+	close(s.done)
+	stopped <- err
+	return err
+}
+
+func (s *Supervisor) supervise(stopCh chan context.Context) error {
+	for i := range s.children {
+		if err := s.startChild(i); err != nil {
+			s.stopAll(context.Background(), len(s.children))
+			return err
+		}
+	}
+	for {
+		select {
+		case ctx := <-stopCh:
+			s.stopAll(ctx, len(s.children))
+			return nil
+		case exit := <-s.exits:
+			if err := s.handleExit(exit); err != nil {
+				s.stopAll(context.Background(), len(s.children))
+				return err
+			}
+		}
+	}
+}
+
+// startChild 启动子节点，Start成功之后在后台执行Run
+func (s *Supervisor) startChild(i int) error {
+	c := s.children[i]
+	if c.spec.Start != nil {
+		if err := safeCall(c.spec.Start); err != nil {
+			base.Zap().Sugar().Errorf("supervisor %s: start %s failed: %v", s.Name, c.spec.Name, err)
+			return fmt.Errorf("supervisor %s: start %s: %w", s.Name, c.spec.Name, err)
+		}
+	}
+	c.gen++
+	c.running = true
+	gen := c.gen
+	exits, done := s.exits, s.done
+	go func() {
+		exit := childExit{index: i, gen: gen, err: safeCall(c.spec.Run)}
+		select {
+		case exits <- exit:
+		case <-done:
+			// 停止超时之后才退出，Run已经返回
+		}
+	}()
+	return nil
+}
+
+// safeCall 执行函数，panic转换成错误
+func safeCall(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return f()
+}
+
+// handleExit 子节点退出，返回错误表示放弃
+func (s *Supervisor) handleExit(exit childExit) error {
+	c := s.children[exit.index]
+	if exit.gen != c.gen || !c.running {
+		return nil
+	}
+	c.running = false
+	if exit.err != nil {
+		base.Zap().Sugar().Errorf("supervisor %s: child %s exited: %v", s.Name, c.spec.Name, exit.err)
+	} else {
+		base.Zap().Sugar().Infof("supervisor %s: child %s exited", s.Name, c.spec.Name)
+	}
+	switch c.spec.Restart {
+	case RestartTemporary:
+		return nil
+	case RestartTransient:
+		if exit.err == nil {
+			return nil
+		}
+	}
+
+	for {
+		if !s.allowRestart() {
+			base.Zap().Sugar().Errorf("supervisor %s: too many restarts, giving up", s.Name)
+			if exit.err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrRestartIntensity, c.spec.Name, exit.err)
+			}
+			return ErrRestartIntensity
+		}
+		var err error
+		if s.Strategy == AllForOne {
+			s.stopAll(context.Background(), len(s.children))
+			for i := range s.children {
+				if err = s.startChild(i); err != nil {
+					s.stopAll(context.Background(), i)
+					break
+				}
+			}
+		} else {
+			err = s.startChild(exit.index)
+		}
+		if err == nil {
+			return nil
+		}
+		// 重启失败也计入重启次数
+		exit.err = err
+	}
+}
+
+// allowRestart 记录一次重启，超过强度限制时返回false
+func (s *Supervisor) allowRestart() bool {
+	now := time.Now()
+	kept := s.restarts[:0]
+	for _, t := range s.restarts {
+		if now.Sub(t) < s.Period {
+			kept = append(kept, t)
+		}
+	}
+	s.restarts = append(kept, now)
+	return len(s.restarts) <= s.MaxRestarts
+}
+
+// stopAll 逆序停止前n个正在运行的子节点，并等待它们退出
+func (s *Supervisor) stopAll(ctx context.Context, n int) {
+	for i := n - 1; i >= 0; i-- {
+		c := s.children[i]
+		if !c.running {
+			continue
+		}
+		cctx := ctx
+		var cancel context.CancelFunc = func() {}
+		if s.ShutdownTimeout > 0 {
+			cctx, cancel = context.WithTimeout(ctx, s.ShutdownTimeout)
+		}
+		if c.spec.Stop != nil {
+			if err := c.spec.Stop(cctx); err != nil {
+				base.Zap().Sugar().Warnf("supervisor %s: stop %s: %v", s.Name, c.spec.Name, err)
+			}
+		}
+		s.waitExit(cctx, i)
+		cancel()
+	}
+}
+
+// waitExit 等待子节点退出，期间其他子节点的退出只做记录
+func (s *Supervisor) waitExit(ctx context.Context, i int) {
+	c := s.children[i]
+	for c.running {
+		select {
+		case exit := <-s.exits:
+			if other := s.children[exit.index]; exit.gen == other.gen {
+				other.running = false
+			}
+		case <-ctx.Done():
+			base.Zap().Sugar().Errorf("supervisor %s: child %s did not stop: %v", s.Name, c.spec.Name, ctx.Err())
+			c.running = false
+			return
+		}
+	}
+}
+
+// Stop 逆序停止所有子节点，Run返回之后返回
+// Run之前调用时记录下来，之后的Run不启动子节点直接返回
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.running {
+		s.stopPending = true
+		s.mu.Unlock()
+		return nil
+	}
+	stopCh, stopped := s.stopCh, s.stopped
+	s.mu.Unlock()
+	select {
+	case stopCh <- ctx:
+	case err := <-stopped:
+		// 已经自己退出
+		stopped <- err
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-stopped:
+		stopped <- err
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Serve 运行监督树，收到退出信号时逆序停止所有子节点，timeout为停止的总超时时间
+func (s *Supervisor) Serve(timeout time.Duration) error {
+	go base.OnExit(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		s.Stop(ctx)
+	})
+	return s.Run()
+}
+
+// Child 作为上级监督树的子节点
+func (s *Supervisor) Child() ChildSpec {
+	return ChildSpec{
+		Name: s.Name,
+		Run:  s.Run,
+		Stop: s.Stop,
+	}
+}
+
+// ProcessorChild 处理器子节点，处理循环panic退出时重启，ExitEvent正常退出不重启
+func ProcessorChild(name string, p *Processor) ChildSpec {
+	if p.group != nil {
+		return ProcessorGroupChild(name, p.group)
+	}
+	return ChildSpec{
+		Name:    name,
+		Restart: RestartTransient,
+		Start: func() error {
+			if !p.begin() {
+				return errProcessorRunning
+			}
+			return nil
+		},
+		Run: p.loop,
+		Stop: func(ctx context.Context) error {
+			return p.Stop(ctx, StopDrain)
+		},
+	}
+}
+
+// ProcessorGroupChild 处理器组子节点
+func ProcessorGroupChild(name string, g *ProcessorGroup) ChildSpec {
+	return ChildSpec{
+		Name:    name,
+		Restart: RestartTransient,
+		Start:   g.begin,
+		Run:     g.loop,
+		Stop: func(ctx context.Context) error {
+			return g.Stop(ctx, StopDrain)
+		},
+	}
+}
+
+// Service 异步启动的服务，AsyncTCPServer和kcp.AsyncKCPServer都满足
+type Service interface {
+	StartAsync() error
+	Stop()
+}
+
+// ServiceExit 能报告自己退出的服务，AsyncTCPServer和kcp.AsyncKCPServer都满足
+// 监听出错退出或者Stop时Done关闭，Err返回出错的原因，Stop退出时为nil
+type ServiceExit interface {
+	Done() <-chan struct{}
+	Err() error
+}
+
+// errServiceExited 服务自己退出但没有给出原因
+var errServiceExited = errors.New("service exited")
+
+// ServiceChild 服务子节点，每次启动都用factory创建新的服务，因为停止的服务器不能再次启动
+// 服务实现了ServiceExit时，监听出错退出会让Run返回错误，由监督树重启
+func ServiceChild(name string, factory func() (Service, error)) ChildSpec {
+	var (
+		mu      sync.Mutex
+		current Service
+		quit    chan struct{}
+	)
+	return ChildSpec{
+		Name: name,
+		Start: func() error {
+			svc, err := factory()
+			if err != nil {
+				return err
+			}
+			if err := svc.StartAsync(); err != nil {
+				svc.Stop()
+				return err
+			}
+			mu.Lock()
+			current, quit = svc, make(chan struct{})
+			mu.Unlock()
+			return nil
+		},
+		Run: func() error {
+			mu.Lock()
+			q, svc := quit, current
+			mu.Unlock()
+			var died <-chan struct{}
+			exit, ok := svc.(ServiceExit)
+			if ok {
+				died = exit.Done()
+			}
+			select {
+			case <-q:
+				return nil
+			case <-died:
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if current != svc {
+				// 已经被Stop
+				return nil
+			}
+			// 服务自己退出，先释放它的资源，重启时创建新的服务
+			svc.Stop()
+			close(quit)
+			current = nil
+			if err := exit.Err(); err != nil {
+				return err
+			}
+			return errServiceExited
+		},
+		Stop: func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if current != nil {
+				current.Stop()
+				close(quit)
+				current = nil
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,216 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testChild 记录启动和停止顺序的子节点
+func testChild(name string, log *[]string, mu *sync.Mutex, crash chan error) ChildSpec {
+	quit := make(chan struct{}, 1)
+	record := func(s string) {
+		mu.Lock()
+		*log = append(*log, s)
+		mu.Unlock()
+	}
+	return ChildSpec{
+		Name:  name,
+		Start: func() error { record("start " + name); return nil },
+		Run: func() error {
+			select {
+			case <-quit:
+				return nil
+			case err := <-crash:
+				return err
+			}
+		},
+		Stop: func(ctx context.Context) error {
+			record("stop " + name)
+			quit <- struct{}{}
+			return nil
+		},
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	var mu sync.Mutex
+	var log []string
+	crash := make(chan error)
+	sup := NewSupervisor("test", AllForOne, 1, time.Minute)
+	sup.Add(testChild("a", &log, &mu, nil), testChild("b", &log, &mu, crash), testChild("c", &log, &mu, nil))
+
+	result := make(chan error, 1)
+	go func() { result <- sup.Run() }()
+
+	crash <- errors.New("boom")
+	// 第二次重启超过强度限制
+	crash <- errors.New("boom")
+	err := <-result
+	if !errors.Is(err, ErrRestartIntensity) {
+		t.Fatalf("expected intensity error, got %v", err)
+	}
+	want := []string{
+		"start a", "start b", "start c",
+		"stop c", "stop a", "start a", "start b", "start c",
+		"stop c", "stop a",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(log) != len(want) {
+		t.Fatalf("unexpected log %v", log)
+	}
+	for i := range want {
+		if log[i] != want[i] {
+			t.Fatalf("unexpected log %v", log)
+		}
+	}
+}
+
+func TestSupervisorProcessor(t *testing.T) {
+	proc := NewProcessor()
+	sup := NewSupervisor("proc", OneForOne, 3, time.Minute)
+	sup.Add(ProcessorChild("main", proc))
+	result := make(chan error, 1)
+	go func() { result <- sup.Run() }()
+
+	// 处理循环本身panic后被重启
+	waitRunning(proc)
+	done := proc.Done()
+	proc.MessageChan <- nil
+	<-done
+	handled := make(chan struct{})
+	proc.FuncChan <- func() { close(handled) }
+	<-handled
+
+	if err := sup.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil || !proc.Stopped() {
+		t.Fatalf("supervisor stop failed: %v", err)
+	}
+}
+
+func TestSupervisorProcessorGroup(t *testing.T) {
+	group := NewProcessorGroup(2)
+	sup := NewSupervisor("group", OneForOne, 3, time.Minute)
+	sup.Add(ProcessorGroupChild("shards", group))
+	result := make(chan error, 1)
+	go func() { result <- sup.Run() }()
+
+	// 一个分片panic时其他分片也停止，整个组一起重启
+	first, second := group.Shards()[0], group.Shards()[1]
+	waitRunning(first)
+	waitRunning(second)
+	done := first.Done()
+	second.MessageChan <- nil
+	<-done
+	handled := make(chan struct{})
+	first.FuncChan <- func() { close(handled) }
+	<-handled
+
+	if err := sup.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil || !first.Stopped() || !second.Stopped() {
+		t.Fatalf("supervisor stop failed: %v", err)
+	}
+}
+
+// testService 可以模拟监听出错的服务
+type testService struct {
+	done    chan struct{}
+	err     error
+	stopped chan struct{}
+}
+
+func (s *testService) StartAsync() error     { return nil }
+func (s *testService) Stop()                 { close(s.stopped) }
+func (s *testService) Done() <-chan struct{} { return s.done }
+func (s *testService) Err() error            { return s.err }
+
+func TestSupervisorServiceExit(t *testing.T) {
+	services := make(chan *testService, 2)
+	sup := NewSupervisor("service", OneForOne, 3, time.Minute)
+	sup.Add(ServiceChild("listener", func() (Service, error) {
+		svc := &testService{done: make(chan struct{}), stopped: make(chan struct{})}
+		services <- svc
+		return svc, nil
+	}))
+	result := make(chan error, 1)
+	go func() { result <- sup.Run() }()
+
+	// 监听出错退出后释放旧的服务，创建新的服务
+	svc := <-services
+	svc.err = errors.New("listener closed")
+	close(svc.done)
+	<-svc.stopped
+	restarted := <-services
+
+	if err := sup.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-restarted.stopped:
+	default:
+		t.Fatal("restarted service not stopped")
+	}
+}
+
+func TestSupervisorStopBeforeRun(t *testing.T) {
+	started := make(chan struct{}, 1)
+	sup := NewSupervisor("early", OneForOne, 1, time.Minute)
+	sup.Add(ChildSpec{
+		Name:  "child",
+		Start: func() error { started <- struct{}{}; return nil },
+		Run:   func() error { select {} },
+	})
+	// Run之前的Stop让之后的Run直接返回
+	if err := sup.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sup.Run(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+		t.Fatal("child started after Stop")
+	default:
+	}
+}
+
+func TestSupervisorShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	exited := make(chan struct{})
+	sup := NewSupervisor("slow", OneForOne, 1, time.Minute)
+	sup.ShutdownTimeout = 10 * time.Millisecond
+	sup.Add(ChildSpec{
+		Name: "slow",
+		Run: func() error {
+			defer close(exited)
+			<-release
+			return nil
+		},
+	})
+	result := make(chan error, 1)
+	go func() { result <- sup.Run() }()
+	for running := false; !running; time.Sleep(time.Millisecond) {
+		sup.mu.Lock()
+		running = sup.running
+		sup.mu.Unlock()
+	}
+	if err := sup.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	// 停止超时之后Run返回，子节点之后才退出
+	close(release)
+	<-exited
+}
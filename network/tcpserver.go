@@ -3,6 +3,7 @@ package network
 import (
 	"errors"
 	"net"
+	"sync"
 	"sync/atomic"
 
 	"github.com/liangpengcheng/qcontinuum/base"
@@ -17,6 +18,10 @@ type AsyncTCPServer struct {
 	running      int32
 	acceptCount  uint64
 	connCount    uint64
+	// 服务退出，监听socket出错或者Stop时关闭，exitErr是出错的原因
+	done     chan struct{}
+	exitOnce sync.Once
+	exitErr  error
 }
 
 // NewAsyncTCP4Server 创建异步TCP服务器
@@ -58,6 +63,7 @@ func NewAsyncTCP4Server(bindAddress string) (*AsyncTCPServer, error) {
 		listener:    listener,
 		reactorPool: reactorPool,
 		fd:          fd,
+		done:        make(chan struct{}),
 	}
 	
 	return server, nil
@@ -86,6 +92,7 @@ func (s *AsyncTCPServer) StartAsync() error {
 // Stop 停止服务器
 func (s *AsyncTCPServer) Stop() {
 	atomic.StoreInt32(&s.running, 0)
+	s.exit(nil)
 	
 	if s.listener != nil {
 		s.listener.Close()
@@ -107,6 +114,9 @@ func (s *AsyncTCPServer) OnRead(fd int, data []byte) error {
 				break // 没有更多连接
 			}
 			base.Zap().Sugar().Errorf("accept error: %v", err)
+			if atomic.LoadInt32(&s.running) == 1 {
+				s.exit(err)
+			}
 			return err
 		}
 		
@@ -135,16 +145,9 @@ func (s *AsyncTCPServer) OnRead(fd int, data []byte) error {
 		}
 		
 		// 发送连接事件
-		event := &Event{
-			ID:   AddEvent,
-			Peer: &ClientPeer{AsyncClientPeer: peer},
-		}
+		event := peer.ConnectedEvent()
 		
-		select {
-		case s.processor.EventChan <- event:
-		default:
-			base.Zap().Sugar().Warnf("event queue full, dropping add event")
-		}
+		s.processor.PostEvent(event)
 		
 		atomic.AddUint64(&s.acceptCount, 1)
 		atomic.AddUint64(&s.connCount, 1)
@@ -169,6 +172,32 @@ func (s *AsyncTCPServer) OnError(fd int, err error) {
 // OnClose 实现AsyncIOHandler接口
 func (s *AsyncTCPServer) OnClose(fd int) {
 	base.Zap().Sugar().Infof("server socket closed")
+	if atomic.LoadInt32(&s.running) == 1 {
+		s.exit(errors.New("listener closed"))
+	}
+}
+
+// exit 记录退出原因并关闭Done，只有第一次有效
+func (s *AsyncTCPServer) exit(err error) {
+	s.exitOnce.Do(func() {
+		s.exitErr = err
+		close(s.done)
+	})
+}
+
+// Done 服务退出时关闭，监听socket出错退出或者调用了Stop
+func (s *AsyncTCPServer) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err Done关闭之后返回退出的原因，Stop退出时为nil
+func (s *AsyncTCPServer) Err() error {
+	select {
+	case <-s.done:
+		return s.exitErr
+	default:
+		return nil
+	}
 }
 
 // GetStats 获取服务器统计信息
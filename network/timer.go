@@ -0,0 +1,236 @@
+package network
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Timer 处理器定时器，回调总是在所属处理器的goroutine中执行
+type Timer struct {
+	when   time.Time
+	period time.Duration
+	fn     ProcFunction
+	index  int // 在堆中的位置，-1表示不在堆中
+	queue  *timerQueue
+
+	// 绑定peer的定时器，peer关闭时自动取消
+	session *Session
+	hookID  uint64
+}
+
+// Stop 取消定时器，返回false表示已经触发或者已经取消
+// 可以在任意goroutine中调用
+func (t *Timer) Stop() bool {
+	q := t.queue
+	q.mu.Lock()
+	if t.index < 0 {
+		q.mu.Unlock()
+		return false
+	}
+	heap.Remove(&q.heap, t.index)
+	q.mu.Unlock()
+	t.unbind()
+	return true
+}
+
+// unbind 删除peer关闭时的取消函数
+func (t *Timer) unbind() {
+	if t.session != nil && t.hookID != 0 {
+		t.session.RemoveCloseHook(t.hookID)
+	}
+}
+
+// timerHeap 按触发时间排序的最小堆
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int           { return len(h) }
+func (h timerHeap) Less(i, j int) bool { return h[i].when.Before(h[j].when) }
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
+
+// timerQueue 处理器的定时器队列
+// 所有定时器共用一个运行时timer，添加和取消都是O(log n)，可以支撑几十万个定时器
+type timerQueue struct {
+	mu   sync.Mutex
+	heap timerHeap
+	// 新加入的定时器比当前最早的还早时通知处理器重新设置timer
+	wake chan struct{}
+	// 只在处理器goroutine中使用
+	timer *time.Timer
+}
+
+func newTimerQueue() *timerQueue {
+	return &timerQueue{
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// add 加入定时器
+func (q *timerQueue) add(t *Timer) {
+	q.mu.Lock()
+	heap.Push(&q.heap, t)
+	earliest := t.index == 0
+	q.mu.Unlock()
+	if earliest {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// channel 处理器循环等待的channel
+func (q *timerQueue) channel() <-chan time.Time {
+	if q.timer == nil {
+		q.timer = time.NewTimer(time.Hour)
+		q.reset()
+	}
+	return q.timer.C
+}
+
+// reset 按最早的定时器重新设置运行时timer，只在处理器goroutine中调用
+func (q *timerQueue) reset() {
+	if !q.timer.Stop() {
+		select {
+		case <-q.timer.C:
+		default:
+		}
+	}
+	q.mu.Lock()
+	d := time.Hour
+	if len(q.heap) > 0 {
+		d = time.Until(q.heap[0].when)
+	}
+	q.mu.Unlock()
+	if d < 0 {
+		d = 0
+	}
+	q.timer.Reset(d)
+}
+
+// expired 取出所有到期的定时器，周期定时器重新加入
+func (q *timerQueue) expired(now time.Time) []*Timer {
+	var due []*Timer
+	q.mu.Lock()
+	for len(q.heap) > 0 && !q.heap[0].when.After(now) {
+		t := heap.Pop(&q.heap).(*Timer)
+		due = append(due, t)
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			if t.when.Before(now) {
+				// 处理器被阻塞太久，不补触发
+				t.when = now.Add(t.period)
+			}
+			heap.Push(&q.heap, t)
+		}
+	}
+	q.mu.Unlock()
+	return due
+}
+
+// Len 等待中的定时器数量
+func (q *timerQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.heap)
+}
+
+// fireTimers 执行到期的定时器，只在处理器goroutine中调用
+func (p *Processor) fireTimers() {
+	for _, t := range p.timers.expired(time.Now()) {
+		if t.period == 0 {
+			t.unbind()
+		}
+		p.runFunc("timer", t.fn)
+	}
+	p.timers.reset()
+}
+
+// timerOwner 定时器所在的处理器，处理器组的入口使用第一个分片
+func (p *Processor) timerOwner(peer *ClientPeer) *Processor {
+	if p.group == nil {
+		return p
+	}
+	if peer != nil {
+		return p.Owner(peer)
+	}
+	return p.group.shards[0]
+}
+
+func (p *Processor) newTimer(peer *ClientPeer, d, period time.Duration, f ProcFunction) *Timer {
+	owner := p.timerOwner(peer)
+	t := &Timer{
+		when:   time.Now().Add(d),
+		period: period,
+		fn:     f,
+		index:  -1,
+		queue:  owner.timers,
+	}
+	if peer == nil {
+		owner.timers.add(t)
+		return t
+	}
+	// 会话已经结束时返回已经停止的定时器，不会触发
+	t.session = peer.Session()
+	if t.session.isClosed() {
+		return t
+	}
+	t.hookID = t.session.OnClose(func(*ClientPeer) {
+		t.Stop()
+	})
+	owner.timers.add(t)
+	// 注册和加入之间会话结束时，取消函数已经执行过，这里补上
+	if t.session.isClosed() {
+		t.Stop()
+	}
+	return t
+}
+
+// AfterFunc d时间之后在处理器goroutine中执行f
+func (p *Processor) AfterFunc(d time.Duration, f ProcFunction) *Timer {
+	return p.newTimer(nil, d, 0, f)
+}
+
+// Every 每隔d时间在处理器goroutine中执行f，直到Stop
+func (p *Processor) Every(d time.Duration, f ProcFunction) *Timer {
+	if d <= 0 {
+		panic("non-positive interval for Processor.Every")
+	}
+	return p.newTimer(nil, d, d, f)
+}
+
+// PeerAfterFunc 绑定peer的AfterFunc，peer关闭时自动取消，peer已经关闭时不会触发
+func (p *Processor) PeerAfterFunc(peer *ClientPeer, d time.Duration, f ProcFunction) *Timer {
+	return p.newTimer(peer, d, 0, f)
+}
+
+// PeerEvery 绑定peer的Every，peer关闭时自动取消，peer已经关闭时不会触发
+func (p *Processor) PeerEvery(peer *ClientPeer, d time.Duration, f ProcFunction) *Timer {
+	if d <= 0 {
+		panic("non-positive interval for Processor.PeerEvery")
+	}
+	return p.newTimer(peer, d, d, f)
+}
+
+// PendingTimers 等待中的定时器数量
+func (p *Processor) PendingTimers() int {
+	return p.timerOwner(nil).timers.Len()
+}
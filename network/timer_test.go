@@ -0,0 +1,111 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+func TestProcessorTimers(t *testing.T) {
+	proc := NewProcessor()
+	go proc.StartProcess()
+	defer proc.PostEventWait(&Event{ID: ExitEvent})
+
+	fired := make(chan string, 16)
+	stopped := proc.AfterFunc(10*time.Millisecond, func() { fired <- "stopped" })
+	proc.AfterFunc(20*time.Millisecond, func() { fired <- "late" })
+	proc.AfterFunc(5*time.Millisecond, func() { fired <- "early" })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatalf("unexpected stop result")
+	}
+	if a, b := <-fired, <-fired; a != "early" || b != "late" {
+		t.Fatalf("unexpected order %s %s", a, b)
+	}
+
+	ticks := 0
+	everyC := make(chan *Timer, 1)
+	everyC <- proc.Every(time.Millisecond, func() {
+		ticks++
+		if ticks == 3 {
+			(<-everyC).Stop()
+			fired <- "every"
+		}
+	})
+	if <-fired != "every" {
+		t.Fatalf("periodic timer did not fire")
+	}
+
+	peer := NewWebSocketClientPeer(&recordConn{}, proc)
+	proc.PeerEvery(peer, time.Millisecond, func() {})
+	proc.PeerAfterFunc(peer, time.Hour, func() {})
+	peer.Close()
+	done := make(chan int)
+	proc.FuncChan <- func() { done <- proc.PendingTimers() }
+	if n := <-done; n != 0 {
+		t.Fatalf("peer timers not cancelled, %d pending", n)
+	}
+
+	// peer已经关闭，新的定时器不会触发
+	late := proc.PeerEvery(peer, time.Millisecond, func() { fired <- "closed peer" })
+	proc.PeerAfterFunc(peer, 0, func() { fired <- "closed peer" })
+	if late.Stop() {
+		t.Fatal("timer on closed peer was scheduled")
+	}
+	proc.FuncChan <- func() { done <- proc.PendingTimers() }
+	if n := <-done; n != 0 {
+		t.Fatalf("%d timers pending for closed peer", n)
+	}
+	select {
+	case s := <-fired:
+		t.Fatalf("unexpected %s timer", s)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestProcessorTimingWheel(t *testing.T) {
+	proc := NewProcessor()
+	proc.SetTimingWheel(base.NewTimingWheel(time.Millisecond))
+	go proc.StartProcess()
+	defer proc.PostEventWait(&Event{ID: ExitEvent})
+
+	fired := make(chan struct{})
+	proc.TimingWheel().AfterFunc(5*time.Millisecond, func() { panic("wheel") })
+	proc.TimingWheel().AfterFunc(10*time.Millisecond, func() { close(fired) })
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatalf("wheel timer not fired by processor loop")
+	}
+
+	// 时间轮空了之后停止计时，再添加定时器时重新开始
+	time.Sleep(5 * time.Millisecond)
+	again := make(chan struct{})
+	proc.TimingWheel().AfterFunc(time.Millisecond, func() { close(again) })
+	select {
+	case <-again:
+	case <-time.After(time.Second):
+		t.Fatalf("wheel timer added to an idle processor not fired")
+	}
+}
+
+func TestProcessorGroupTimingWheel(t *testing.T) {
+	g := NewProcessorGroup(2)
+	entry := g.Processor()
+	entry.SetTimingWheel(base.NewTimingWheel(time.Millisecond))
+	go entry.StartProcess()
+	defer entry.Stop(context.Background(), StopNow)
+
+	// 入口的时间轮由第一个分片推进
+	fired := make(chan bool)
+	entry.TimingWheel().AfterFunc(time.Millisecond, func() { fired <- g.Shards()[0].OnLoop() })
+	select {
+	case onLoop := <-fired:
+		if !onLoop {
+			t.Fatal("wheel callback not on the first shard")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("group wheel timer not fired")
+	}
+}
@@ -0,0 +1,79 @@
+package network
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/liangpengcheng/qcontinuum/base"
+)
+
+// wheelHolder reactor或处理器运行中也可以设置的时间轮
+type wheelHolder struct {
+	ptr unsafe.Pointer
+}
+
+func (h *wheelHolder) load() *base.TimingWheel {
+	return (*base.TimingWheel)(atomic.LoadPointer(&h.ptr))
+}
+
+func (h *wheelHolder) store(w *base.TimingWheel) {
+	atomic.StorePointer(&h.ptr, unsafe.Pointer(w))
+}
+
+// waitMillis 事件循环的等待时间，不超过时间轮的精度
+func (h *wheelHolder) waitMillis(def int) int {
+	if w := h.load(); w != nil {
+		if ms := int(w.Tick() / time.Millisecond); ms < def {
+			if ms < 1 {
+				ms = 1
+			}
+			return ms
+		}
+	}
+	return def
+}
+
+// advance 推进时间轮，单个回调panic不影响事件循环
+func (h *wheelHolder) advance() {
+	w := h.load()
+	if w == nil {
+		return
+	}
+	for _, f := range w.Poll(time.Now()) {
+		runWheelFunc(f)
+	}
+}
+
+func runWheelFunc(f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			base.Zap().Sugar().Errorf("timing wheel callback panic: %v\n%s", err, debug.Stack())
+		}
+	}()
+	f()
+}
+
+// SetTimingWheel 由reactor的事件循环推进时间轮，回调在reactor的goroutine中执行，
+// 必须很快返回，不能阻塞I/O；传nil取消
+func (r *EpollReactor) SetTimingWheel(w *base.TimingWheel) {
+	r.wheel.store(w)
+}
+
+// SetTimingWheel 由处理器循环推进时间轮，回调在处理器goroutine中执行，
+// 处理器组的入口交给第一个分片推进，必须在StartProcess之前设置
+func (p *Processor) SetTimingWheel(w *base.TimingWheel) {
+	if p.group != nil {
+		p = p.group.shards[0]
+	}
+	p.wheel = w
+}
+
+// TimingWheel 处理器推进的时间轮
+func (p *Processor) TimingWheel() *base.TimingWheel {
+	if p.group != nil {
+		p = p.group.shards[0]
+	}
+	return p.wheel
+}
@@ -63,18 +63,15 @@ func NewWebSocket(path string, proc *Processor) {
 			// 为WebSocket创建专用的peer
 			peer := NewWebSocketClientPeer(wsPeer, proc)
 
-			event := &Event{
-				ID:   AddEvent,
-				Peer: peer,
-			}
-			proc.EventChan <- event
+			proc.PostEvent(peer.ConnectedEvent())
 			defer func() {
-				leaveEvent := &Event{
-					ID:   RemoveEvent,
-					Peer: peer,
+				// 可恢复会话挂起时为SuspendEvent
+				if leaveEvent := peer.LeaveEvent(); leaveEvent != nil {
+					peer.Processor().PostEvent(leaveEvent)
 				}
-				proc.EventChan <- leaveEvent
 			}()
+			// 关闭peer，执行会话清理函数
+			defer peer.Close()
 
 			// 使用零拷贝消息读取器
 			reader := NewAsyncMessageReader()
@@ -98,6 +95,7 @@ func NewWebSocket(path string, proc *Processor) {
 				if err != nil {
 					buffer.Release()
 					base.Zap().Sugar().Infof("websocket read error: %v", err)
+					peer.SetReadError(err)
 					return
 				}
 
@@ -111,7 +109,7 @@ func NewWebSocket(path string, proc *Processor) {
 				buffer.Release() // 立即释放缓冲区
 
 				if err != nil {
-					base.Zap().Sugar().Warnf("message parse error: %v", err)
+					peer.ReportParseError(err)
 					continue
 				}
 
@@ -123,15 +121,7 @@ func NewWebSocket(path string, proc *Processor) {
 						Body: zcMsg.GetBody(), // 零拷贝获取消息体
 					}
 
-					if proc.ImmediateMode {
-						if cb, ok := proc.CallbackMap[msg.Head.ID]; ok {
-							cb(msg)
-						} else if proc.UnHandledHandler != nil {
-							proc.UnHandledHandler(msg)
-						}
-					} else {
-						proc.MessageChan <- msg
-					}
+					peer.Processor().PostMessage(msg)
 
 					// 释放零拷贝消息
 					zcMsg.Release()
@@ -0,0 +1,155 @@
+package network
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// 默认工作池：每个CPU一个goroutine，最多1024个等待执行的任务
+const defaultWorkerCapacity = 1024
+
+/*
+workerPool 有界工作池
+
+  - 同一个key的任务按提交顺序依次执行，同一时间只有一个在执行，不同key的任务并发执行
+  - key为0的任务之间没有顺序保证
+  - 等待和执行中的任务总数不超过capacity，goroutine在第一次提交时启动
+  - 处理器停止时stop结束goroutine，还没执行的任务留在队列中，下次提交时重新启动
+*/
+type workerPool struct {
+	workers int
+	ready   chan func()
+	slots   chan struct{}
+
+	mu   sync.Mutex
+	keys map[uint64][]func() // 有任务在执行的key，以及排在后面的任务
+	quit chan struct{}       // 当前这批goroutine的退出信号，没有启动时为nil
+}
+
+func newWorkerPool(workers, capacity int) *workerPool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if capacity < workers {
+		capacity = workers
+	}
+	return &workerPool{
+		workers: workers,
+		ready:   make(chan func(), capacity),
+		slots:   make(chan struct{}, capacity),
+		keys:    make(map[uint64][]func()),
+	}
+}
+
+// WithWorkers 设置工作池的goroutine数量和最多等待执行的任务数量，workers为0时使用CPU数量
+func WithWorkers(workers, capacity int) ProcessorOption {
+	return func(p *Processor) {
+		p.workers = newWorkerPool(workers, capacity)
+	}
+}
+
+func (w *workerPool) start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.quit != nil {
+		return
+	}
+	w.quit = make(chan struct{})
+	for i := 0; i < w.workers; i++ {
+		go w.work(w.quit)
+	}
+}
+
+// stop 结束工作goroutine，正在执行的任务执行完才退出
+func (w *workerPool) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.quit != nil {
+		close(w.quit)
+		w.quit = nil
+	}
+}
+
+func (w *workerPool) work(quit <-chan struct{}) {
+	for {
+		select {
+		case job := <-w.ready:
+			job()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// submit 提交任务，block为false时工作池满了直接返回false，为true时等到有空间或者quit关闭
+func (w *workerPool) submit(key uint64, job func(), block bool, quit <-chan struct{}) bool {
+	select {
+	case w.slots <- struct{}{}:
+	default:
+		if !block {
+			return false
+		}
+		select {
+		case w.slots <- struct{}{}:
+		case <-quit:
+			return false
+		}
+	}
+	w.start()
+	if key == 0 {
+		w.ready <- func() { w.run(job) }
+		return true
+	}
+	w.mu.Lock()
+	if pending, ok := w.keys[key]; ok {
+		w.keys[key] = append(pending, job)
+		w.mu.Unlock()
+		return true
+	}
+	w.keys[key] = nil
+	w.mu.Unlock()
+	// 占用了slot，ready一定有空间
+	w.ready <- func() { w.runKey(key, job) }
+	return true
+}
+
+// runKey 执行key的任务，然后在同一个goroutine中继续执行这个key排在后面的任务
+func (w *workerPool) runKey(key uint64, job func()) {
+	for {
+		w.run(job)
+		w.mu.Lock()
+		pending := w.keys[key]
+		if len(pending) == 0 {
+			delete(w.keys, key)
+			w.mu.Unlock()
+			return
+		}
+		job = pending[0]
+		pending[0] = nil
+		w.keys[key] = pending[1:]
+		w.mu.Unlock()
+	}
+}
+
+// run 执行任务并释放slot，panic只记录，不影响工作goroutine
+func (w *workerPool) run(job func()) {
+	defer func() {
+		<-w.slots
+		if e := recover(); e != nil {
+			defaultPanicHook(&PanicReport{
+				Kind:  "worker",
+				Value: e,
+				Stack: debug.Stack(),
+				Time:  time.Now(),
+			})
+		}
+	}()
+	job()
+}
+
+// pending 等待和执行中的任务数量
+func (w *workerPool) pending() int {
+	return len(w.slots)
+}